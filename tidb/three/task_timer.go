@@ -4,7 +4,7 @@
  * @Author: kingeasternsun
  * @Date: 2021-02-25 17:01:05
  * @LastEditors: kingeasternsun
- * @LastEditTime: 2021-02-26 14:45:20
+ * @LastEditTime: 2026-10-18 13:20:05
  * @FilePath: \three\task_timer.go
 
  利用最小堆，实现简单的定时任务调度器。
@@ -45,6 +45,8 @@ type TaskTimer struct {
 	Heap TaskHeap
 	mu   sync.Mutex
 	dur  time.Duration //定时器的检查周期
+	stop chan struct{} //停止定时器
+	once sync.Once
 }
 
 //NewTaskTimer 创建任务定时器
@@ -54,6 +56,7 @@ func NewTaskTimer(dur time.Duration) *TaskTimer {
 		Heap: TaskHeap{},
 		mu:   sync.Mutex{},
 		dur:  dur,
+		stop: make(chan struct{}, 0),
 	}
 }
 
@@ -97,13 +100,25 @@ func (t *TaskTimer) Pop() TaskItem {
 func (t *TaskTimer) Run() {
 
 	tk := time.NewTicker(t.dur)
+	defer tk.Stop()
 	for {
 
-		<-tk.C
-		t.checkTask()
+		select {
+		case <-tk.C:
+			t.checkTask()
+		case <-t.stop:
+			return
+		}
 	}
 }
 
+//Stop 停止定时器，还没有到期的任务不会再执行
+func (t *TaskTimer) Stop() {
+	t.once.Do(func() {
+		close(t.stop)
+	})
+}
+
 func (t *TaskTimer) checkTask() {
 
 	for {
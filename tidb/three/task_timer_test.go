@@ -4,7 +4,7 @@
  * @Author: kingeasternsun
  * @Date: 2021-02-25 17:46:02
  * @LastEditors: kingeasternsun
- * @LastEditTime: 2021-02-26 14:53:50
+ * @LastEditTime: 2026-10-18 13:26:41
  * @FilePath: \three\task_timer_test.go
  */
 package three
@@ -146,6 +146,42 @@ func TestAddTaskInMinute(t *testing.T) {
 
 	return
 }
+//TestStop 停止后没有到期的任务不再执行
+func TestStop(t *testing.T) {
+
+	timeUnit := 10 * time.Millisecond
+
+	mu := sync.Mutex{}
+	cnt := 0
+	var task = func(par interface{}) {
+		mu.Lock()
+		cnt++
+		mu.Unlock()
+	}
+
+	tt := NewTaskTimer(timeUnit)
+	stopped := make(chan struct{}, 0)
+	go func() {
+		tt.Run()
+		close(stopped)
+	}()
+
+	tt.AddTimeOut(timeUnit, task, nil)
+	tt.AddTimeOut(50*timeUnit, task, nil)
+	time.Sleep(5 * timeUnit)
+
+	tt.Stop()
+	tt.Stop() //重复调用没有影响
+	<-stopped
+
+	time.Sleep(50 * timeUnit)
+	mu.Lock()
+	if cnt != 1 {
+		t.Errorf("cnt = %v, want %v", cnt, 1)
+	}
+	mu.Unlock()
+}
+
 func abs(x int64) int64 {
 	if x > 0 {
 		return x
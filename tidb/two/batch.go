@@ -0,0 +1,116 @@
+/*
+ * @Description:batch
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-23 14:02:44
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 14:40:51
+ * @FilePath: \tidb\two\batch.go
+ */
+package two
+
+import (
+	"context"
+	"time"
+)
+
+/*
+GetBatch 批量获取最多 max 个item，每次持有锁时把能取到的item一起取出并变为处理中。
+从调用开始最多等待 wait 凑满 max 个，到时返回已经取到的item，一个都没有取到时 items 为空，wait 小于等于0时不等待。
+ctx 结束时已经取到的item仍然返回，需要调用者处理；一个都没有取到时返回 ctx 的错误。
+取到部分item后出错(比如写日志失败或者状态不对)时，items 和 err 一起返回，items 已经是处理中，即使 err 不为nil也需要调用者 Done。
+队列关闭并且没有item时 shutdown 为 true
+*/
+func (q *TiQueue) GetBatch(ctx context.Context, max int, wait time.Duration) (items []Itemer, shutdown bool, err error) {
+
+	if max <= 0 {
+		return nil, false, nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		q.Lock()
+		for len(items) < max {
+			e, err := q.pop()
+			if err != nil {
+				q.Unlock()
+				return items, false, err
+			}
+			if e == nil {
+				break
+			}
+			items = append(items, q.got(e).item)
+		}
+		q.maybeCompact()
+
+		if len(items) == max {
+			q.Unlock()
+			return items, false, nil
+		}
+
+		//队列已经关闭，并且item都被取走了
+		closed := q.closed()
+		if closed && q.items.len() == 0 && !q.retryPending() {
+			q.Unlock()
+			return items, len(items) == 0, nil
+		}
+		if wait <= 0 {
+			q.Unlock()
+			return items, false, nil
+		}
+
+		if q.notEmpty == nil {
+			q.notEmpty = make(chan struct{}, 0)
+		}
+		notEmpty := q.notEmpty
+		done := q.done
+		if closed {
+			done = nil
+		}
+		q.Unlock()
+
+		//等待时间从调用开始计算
+		select {
+		case <-notEmpty:
+		case <-done:
+		case <-timer.C:
+			return items, false, nil
+		case <-ctx.Done():
+			if len(items) > 0 {
+				return items, false, nil
+			}
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+//AddBatch 持有一次锁添加多个item，返回每个item的错误，成功的为nil
+func (q *TiQueue) AddBatch(items []Itemer) []error {
+
+	q.Lock()
+	defer q.Unlock()
+
+	errs := make([]error, len(items))
+	for i, item := range items {
+		if err := q.add(item, 0); err != nil {
+			q.rejected(item, err)
+			errs[i] = q.opError("add", item.GetID(), err)
+		}
+	}
+	return errs
+}
+
+//DoneBatch 持有一次锁完成多个item，返回每个item的错误，成功的为nil
+func (q *TiQueue) DoneBatch(items []Itemer) []error {
+
+	q.Lock()
+	defer q.Unlock()
+
+	errs := make([]error, len(items))
+	for i, item := range items {
+		errs[i] = q.opError("done", item.GetID(), q.markDone(item))
+	}
+	return errs
+}
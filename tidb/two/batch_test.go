@@ -0,0 +1,139 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-23 15:14:30
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 14:40:51
+ * @FilePath: \tidb\two\batch_test.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAddBatch(t *testing.T) {
+
+	q := NewTiQueue(3)
+	errs := q.AddBatch([]Itemer{IntItem(1), IntItem(2), IntItem(1), IntItem(3), IntItem(4)})
+	want := []error{nil, nil, ErrItemExist, nil, ErrExceedCap}
+	for i := range want {
+		if !errors.Is(errs[i], want[i]) {
+			t.Errorf("%v err %v not %v", i, errs[i], want[i])
+		}
+	}
+	if q.Len() != 3 {
+		t.Errorf("len %v not equal 3", q.Len())
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.AddBatch([]Itemer{IntItem(1), IntItem(2), IntItem(3), IntItem(4), IntItem(5)})
+
+	//已经有足够的item，不用等待
+	items, shutdown, err := q.GetBatch(context.Background(), 3, time.Hour)
+	if err != nil || shutdown || len(items) != 3 {
+		t.Fatalf("items %v shutdown %v err %v", items, shutdown, err)
+	}
+	for i, item := range items {
+		if item != IntItem(i+1) {
+			t.Errorf("%v not equal %v", item, i+1)
+		}
+		if status := q.GetItemStatus(item); status != InProcess {
+			t.Errorf("status %v not equal InProcess", status)
+		}
+	}
+
+	//不够时等待 wait 后返回已经取到的
+	start := time.Now()
+	items, _, _ = q.GetBatch(context.Background(), 3, 20*time.Millisecond)
+	if len(items) != 2 || time.Since(start) < 20*time.Millisecond {
+		t.Errorf("items %v after %v", items, time.Since(start))
+	}
+
+	errs := q.DoneBatch([]Itemer{IntItem(1), IntItem(2), IntItem(9)})
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("%v err %v", i, err)
+		}
+	}
+	if q.Contains("1") || !q.Contains("3") {
+		t.Error("done batch")
+	}
+	q.Add(IntItem(6))
+	if errs := q.DoneBatch([]Itemer{IntItem(6)}); !errors.Is(errs[0], ErrItemNotGet) {
+		t.Errorf("err %v not %v", errs[0], ErrItemNotGet)
+	}
+}
+
+//TestGetBatchWait 没有item时阻塞，等待期间加入的item凑到同一批
+func TestGetBatchWait(t *testing.T) {
+
+	q := NewTiQueue(0)
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Add(IntItem(1))
+		time.Sleep(10 * time.Millisecond)
+		q.Add(IntItem(2))
+	}()
+
+	items, _, err := q.GetBatch(context.Background(), 2, 100*time.Millisecond)
+	if err != nil || len(items) != 2 {
+		t.Errorf("items %v err %v", items, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	items, _, err = q.GetBatch(ctx, 2, time.Hour)
+	if err != context.DeadlineExceeded || items != nil {
+		t.Errorf("items %v err %v", items, err)
+	}
+
+	//一个都没有时也只等待 wait，wait 为0时不等待
+	start := time.Now()
+	items, _, err = q.GetBatch(context.Background(), 2, 20*time.Millisecond)
+	if err != nil || len(items) != 0 || time.Since(start) < 20*time.Millisecond || time.Since(start) > time.Second {
+		t.Errorf("items %v err %v after %v", items, err, time.Since(start))
+	}
+	if items, _, err = q.GetBatch(context.Background(), 2, 0); err != nil || len(items) != 0 {
+		t.Errorf("items %v err %v", items, err)
+	}
+
+	q.Add(IntItem(3))
+	q.ShutDown()
+	items, shutdown, _ := q.GetBatch(context.Background(), 2, time.Hour)
+	if len(items) != 1 || shutdown {
+		t.Errorf("items %v shutdown %v", items, shutdown)
+	}
+	items, shutdown, _ = q.GetBatch(context.Background(), 2, time.Hour)
+	if len(items) != 0 || !shutdown {
+		t.Errorf("items %v shutdown %v", items, shutdown)
+	}
+}
+
+//TestGetBatchPartialError 取到部分item后出错，取到的item和错误一起返回，不影响下一次
+func TestGetBatchPartialError(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.AddBatch([]Itemer{IntItem(1), IntItem(2), IntItem(3)})
+	delete(q.ItemStatus, "2")
+
+	items, _, err := q.GetBatch(context.Background(), 3, 0)
+	if !errors.Is(err, ErrStatusNotFound) || len(items) != 1 || items[0] != IntItem(1) {
+		t.Fatalf("items %v err %v", items, err)
+	}
+	if status := q.GetItemStatus(IntItem(1)); status != InProcess {
+		t.Errorf("status %04b", status)
+	}
+
+	items, _, err = q.GetBatch(context.Background(), 3, 0)
+	if err != nil || len(items) != 1 || items[0] != IntItem(3) {
+		t.Errorf("items %v err %v", items, err)
+	}
+}
@@ -0,0 +1,51 @@
+/*
+ * @Description:codec
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 16:02:33
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-26 15:02:44
+ * @FilePath: \tidb\two\codec.go
+ */
+package two
+
+import "encoding/json"
+
+//Codec item 的序列化方式，用于持久化或者网络传输
+type Codec interface {
+	Encode(item Itemer) ([]byte, error)
+	Decode(data []byte) (Itemer, error)
+}
+
+//JSONCodec 用json序列化，T 为item的具体类型，可以是值也可以是指针
+type JSONCodec[T Itemer] struct{}
+
+func (JSONCodec[T]) Encode(item Itemer) ([]byte, error) {
+	return json.Marshal(item)
+}
+
+func (JSONCodec[T]) Decode(data []byte) (Itemer, error) {
+	var v T
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+//RawItem 内容就是唯一标识的item，配合 RawCodec 使用
+type RawItem string
+
+func (i RawItem) GetID() string {
+	return string(i)
+}
+
+//RawCodec 不做序列化，编码时返回item的唯一标识，解码得到 RawItem
+type RawCodec struct{}
+
+func (RawCodec) Encode(item Itemer) ([]byte, error) {
+	return []byte(item.GetID()), nil
+}
+
+func (RawCodec) Decode(data []byte) (Itemer, error) {
+	return RawItem(data), nil
+}
@@ -0,0 +1,209 @@
+/*
+ * @Description:dead letter
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-20 14:31:02
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 11:20:05
+ * @FilePath: \tidb\two\dead_letter.go
+ */
+package two
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var ErrNotDeadLetter = errors.New("item is not dead lettered") //死信中没有这个item
+
+//DeadLetter 超过最大投递次数被放入死信的item
+type DeadLetter struct {
+	Item     Itemer
+	Attempts int       //一共被 Get 了多少次
+	LastErr  error     //最后一次处理失败的原因，租约过期时为 ErrLeaseExpired
+	At       time.Time //放入死信的时间
+}
+
+/*
+DeadLetterStore 保存死信，有自己的锁，可以在不影响队列的情况下查看和清理。
+死信只保存在内存中，持久化的队列重启后死信会丢失
+*/
+type DeadLetterStore struct {
+	sync.Mutex
+	items map[string]DeadLetter
+}
+
+func newDeadLetterStore() *DeadLetterStore {
+	return &DeadLetterStore{items: make(map[string]DeadLetter, 0)}
+}
+
+//List 所有的死信，按放入的时间排序
+func (s *DeadLetterStore) List() []DeadLetter {
+	s.Lock()
+	defer s.Unlock()
+
+	res := make([]DeadLetter, 0, len(s.items))
+	for _, v := range s.items {
+		res = append(res, v)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].At.Before(res[j].At)
+	})
+	return res
+}
+
+//Get 查看某个item的死信
+func (s *DeadLetterStore) Get(id string) (DeadLetter, bool) {
+	s.Lock()
+	defer s.Unlock()
+	dl, ok := s.items[id]
+	return dl, ok
+}
+
+//Len 死信的个数
+func (s *DeadLetterStore) Len() int {
+	s.Lock()
+	defer s.Unlock()
+	return len(s.items)
+}
+
+//Purge 删除某个item的死信，返回是否存在
+func (s *DeadLetterStore) Purge(id string) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.items[id]
+	delete(s.items, id)
+	return ok
+}
+
+//PurgeAll 删除所有死信，返回删除的个数
+func (s *DeadLetterStore) PurgeAll() int {
+	s.Lock()
+	defer s.Unlock()
+	n := len(s.items)
+	s.items = make(map[string]DeadLetter, 0)
+	return n
+}
+
+//put 相同的item再次放入死信时覆盖之前的
+func (s *DeadLetterStore) put(dl DeadLetter) {
+	s.Lock()
+	s.items[dl.Item.GetID()] = dl
+	s.Unlock()
+}
+
+func (s *DeadLetterStore) take(id string) (DeadLetter, bool) {
+	s.Lock()
+	defer s.Unlock()
+	dl, ok := s.items[id]
+	delete(s.items, id)
+	return dl, ok
+}
+
+//SetMaxDeliveries 设置item最多被 Get 多少次，超过后 Nack 或者租约过期时放入死信，n 小于等于0表示不限制
+func (q *TiQueue) SetMaxDeliveries(n int) {
+	q.Lock()
+	q.maxDeliveries = n
+	q.Unlock()
+}
+
+//NumDeliveries item 被 Get 了多少次，处理成功或者放入死信后清零
+func (q *TiQueue) NumDeliveries(item Itemer) int {
+	q.Lock()
+	defer q.Unlock()
+	return q.deliveries[item.GetID()]
+}
+
+//DeadLetters 死信，可以查看和清理
+func (q *TiQueue) DeadLetters() *DeadLetterStore {
+	return q.dlq
+}
+
+/*
+Nack 处理item失败，reason 为失败的原因。
+投递次数还没用完时item重新变为 Ready 等待重试，不受容量限制，队列关闭后也可以；
+投递次数用完时放入死信，dead 返回 true
+*/
+func (q *TiQueue) Nack(item Itemer, reason error) (dead bool, err error) {
+	q.Lock()
+	defer q.Unlock()
+	defer func() { err = q.opError("nack", item.GetID(), err) }()
+
+	status, ok := q.ItemStatus[item.GetID()]
+	if !ok {
+		return
+	}
+	if status == Ready {
+		err = ErrItemNotGet
+		return
+	}
+
+	p := q.active(item.GetID())
+	if p == nil {
+		return
+	}
+	return q.nackInflight(p, reason)
+}
+
+//nackInflight 处理中的记录 p 处理失败，投递次数用完时放入死信，否则重新变为 Ready，调用者需要持有锁
+func (q *TiQueue) nackInflight(p *inflight, reason error) (dead bool, err error) {
+	if q.exhausted(p.item) {
+		err = q.deadLetter(p, reason)
+		return err == nil, err
+	}
+	q.requeueInflight(p)
+	return
+}
+
+//releaseInflight 取出的item没有交给消费者(连接断开、序列化失败)，不算一次投递，重新变为 Ready，调用者需要持有锁
+func (q *TiQueue) releaseInflight(p *inflight) {
+
+	if !q.holding(p) || p.retrying {
+		return
+	}
+	id := p.item.GetID()
+	if q.deliveries[id]--; q.deliveries[id] <= 0 {
+		delete(q.deliveries, id)
+	}
+	q.requeueInflight(p)
+}
+
+//Redrive 把死信重新加入队列，投递次数从0开始，加入失败时仍然保留在死信中
+func (q *TiQueue) Redrive(id string) error {
+
+	dl, ok := q.dlq.take(id)
+	if !ok {
+		return &QueueError{Op: "redrive", ID: id, Err: ErrNotDeadLetter}
+	}
+	if err := q.Add(dl.Item); err != nil {
+		q.dlq.put(dl)
+		return err
+	}
+	return nil
+}
+
+//exhausted item 的投递次数是否用完了，调用者需要持有锁
+func (q *TiQueue) exhausted(item Itemer) bool {
+	return q.maxDeliveries > 0 && q.deliveries[item.GetID()] >= q.maxDeliveries
+}
+
+//deadLetter 结束处理中的item并放入死信，调用者需要持有锁
+func (q *TiQueue) deadLetter(p *inflight, reason error) error {
+
+	id := p.item.GetID()
+	attempts := q.deliveries[id]
+	if err := q.finishAs(EventDeadLetter, p.item, p, reason); err != nil {
+		return err
+	}
+	delete(q.deliveries, id)
+
+	q.dlq.put(DeadLetter{
+		Item:     p.item,
+		Attempts: attempts,
+		LastErr:  reason,
+		At:       time.Now(),
+	})
+	return nil
+}
@@ -0,0 +1,191 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-20 15:24:10
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:14:04
+ * @FilePath: \tidb\two\dead_letter_test.go
+ */
+package two
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//TestNackDeadLetter 失败的item重试到最大次数后放入死信
+func TestNackDeadLetter(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.SetMaxDeliveries(3)
+	q.Add(StringItem("one"))
+
+	errFail := errors.New("fail")
+	for i := 1; i <= 3; i++ {
+		item, _, err := q.Get(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := q.NumDeliveries(item); n != i {
+			t.Errorf("deliveries %v not equal %v", n, i)
+		}
+		dead, err := q.Nack(item, errFail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dead != (i == 3) {
+			t.Errorf("attempt %v dead %v", i, dead)
+		}
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+	if status := q.GetItemStatus(StringItem("one")); status != NotExist {
+		t.Errorf("status %v not equal NotExist", status)
+	}
+	if n := q.NumDeliveries(StringItem("one")); n != 0 {
+		t.Errorf("deliveries %v not equal 0", n)
+	}
+
+	dl, ok := q.DeadLetters().Get("one")
+	if !ok {
+		t.Fatal("one not dead lettered")
+	}
+	if dl.Attempts != 3 || dl.LastErr != errFail || dl.At.IsZero() {
+		t.Errorf("dead letter %+v", dl)
+	}
+	if list := q.DeadLetters().List(); len(list) != 1 || list[0].Item.GetID() != "one" {
+		t.Errorf("list %v", list)
+	}
+}
+
+//TestNackRequeue Nack 后item重新加入队尾，Done 后投递次数清零
+func TestNackRequeue(t *testing.T) {
+
+	q := NewTiQueue(2)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+
+	item, _, _ := q.Get(false)
+	//队列满的时候也可以重新加入
+	q.Add(StringItem("three"))
+	if _, err := q.Nack(item, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status := q.GetItemStatus(item); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+
+	for _, want := range []string{"two", "three", "one"} {
+		item, _, _ = q.Get(false)
+		if item.GetID() != want {
+			t.Errorf("%v not equal %v", item.GetID(), want)
+		}
+	}
+	if n := q.NumDeliveries(item); n != 2 {
+		t.Errorf("deliveries %v not equal 2", n)
+	}
+	q.Done(item)
+	if n := q.NumDeliveries(item); n != 0 {
+		t.Errorf("deliveries %v not equal 0", n)
+	}
+
+	//没有 Get 的item不能 Nack
+	if _, err := q.Nack(StringItem("two"), nil); err != nil {
+		t.Fatal(err)
+	}
+	q.Add(StringItem("four"))
+	if _, err := q.Nack(StringItem("four"), nil); !errors.Is(err, ErrItemNotGet) {
+		t.Errorf("err %v not %v", err, ErrItemNotGet)
+	}
+}
+
+//TestNackInprocessAdd 处理中的item又被加入了一次，Nack 后队列中只有一个
+func TestNackInprocessAdd(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.Add(StringItem("one"))
+	item, _, _ := q.Get(false)
+	q.Add(StringItem("one"))
+
+	q.Nack(item, nil)
+	if status := q.GetItemStatus(item); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+	if q.Len() != 1 {
+		t.Errorf("len %v not equal 1", q.Len())
+	}
+}
+
+//TestLeaseExpireDeadLetter 租约过期也算一次失败的投递
+func TestLeaseExpireDeadLetter(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.SetMaxDeliveries(2)
+	q.Add(StringItem("one"))
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := q.GetWithLease(false, 10*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(40 * time.Millisecond)
+	}
+
+	dl, ok := q.DeadLetters().Get("one")
+	if !ok {
+		t.Fatal("one not dead lettered")
+	}
+	if dl.Attempts != 2 || dl.LastErr != ErrLeaseExpired {
+		t.Errorf("dead letter %+v", dl)
+	}
+	if status := q.GetItemStatus(StringItem("one")); status != NotExist {
+		t.Errorf("status %v not equal NotExist", status)
+	}
+}
+
+//TestRedrivePurge 死信重新加入队列或者删除
+func TestRedrivePurge(t *testing.T) {
+
+	q := NewTiQueue(1)
+	q.SetMaxDeliveries(1)
+	for _, id := range []string{"one", "two", "three"} {
+		q.Add(StringItem(id))
+		item, _, _ := q.Get(false)
+		q.Nack(item, nil)
+	}
+	if n := q.DeadLetters().Len(); n != 3 {
+		t.Fatalf("dead letters %v not equal 3", n)
+	}
+
+	if err := q.Redrive("four"); !errors.Is(err, ErrNotDeadLetter) {
+		t.Errorf("err %v not %v", err, ErrNotDeadLetter)
+	}
+	if err := q.Redrive("one"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := q.DeadLetters().Get("one"); ok {
+		t.Error("one still dead lettered")
+	}
+	//队列满了，加入失败时仍然保留在死信中
+	if err := q.Redrive("two"); !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+	if _, ok := q.DeadLetters().Get("two"); !ok {
+		t.Error("two not dead lettered")
+	}
+
+	item, _, _ := q.Get(false)
+	if item.GetID() != "one" || q.NumDeliveries(item) != 1 {
+		t.Errorf("item %v deliveries %v", item.GetID(), q.NumDeliveries(item))
+	}
+
+	if !q.DeadLetters().Purge("two") || q.DeadLetters().Purge("two") {
+		t.Error("purge two")
+	}
+	if n := q.DeadLetters().PurgeAll(); n != 1 {
+		t.Errorf("purge all %v not equal 1", n)
+	}
+}
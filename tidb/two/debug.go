@@ -0,0 +1,254 @@
+/*
+ * @Description:debug
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-25 14:02:37
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-25 16:18:09
+ * @FilePath: \tidb\two\debug.go
+ */
+package two
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrNotInProcess = errors.New("item is not in process") //Requeue 的item不在处理中
+
+//AuthFunc 检查管理操作的请求是否有权限，返回 false 时拒绝
+type AuthFunc func(r *http.Request) bool
+
+//QueueSnapshot 队列的快照
+type QueueSnapshot struct {
+	Len         int            `json:"len"`
+	Cap         int            `json:"cap"` //0表示不限制
+	ShutDown    bool           `json:"shutdown"`
+	InProcess   int            `json:"in_process"`
+	DeadLetters int            `json:"dead_letters"`
+	Items       []ItemSnapshot `json:"items"`
+	Truncated   bool           `json:"truncated,omitempty"` //只返回了 limit 个item
+}
+
+//ItemSnapshot 一个item的状态，States 和2个bit的编码一一对应，最早的在前面
+type ItemSnapshot struct {
+	ID     string          `json:"id"`
+	Status string          `json:"status"` //ItemStatus 的二进制，比如 0110
+	States []StateSnapshot `json:"states"`
+}
+
+//StateSnapshot item 的一份拷贝所处的状态
+type StateSnapshot struct {
+	State      string    `json:"state"` //ready、in_process，等待重试的为 retrying
+	Since      time.Time `json:"since"`
+	Seconds    float64   `json:"seconds"` //在这个状态已经多久了
+	Deliveries int       `json:"deliveries,omitempty"`
+}
+
+/*
+Snapshot 队列的快照，item 按ID排序，limit 大于0时最多返回 limit 个item。
+Ready 的时间从加入队列开始计算，处理中的时间从 Get 开始计算
+*/
+func (q *TiQueue) Snapshot(limit int) QueueSnapshot {
+
+	q.Lock()
+	defer q.Unlock()
+
+	now := time.Now()
+	s := QueueSnapshot{
+		Len:         q.items.len(),
+		Cap:         q.maxCap,
+		ShutDown:    q.closed(),
+		DeadLetters: q.dlq.Len(),
+	}
+	for _, ps := range q.processing {
+		s.InProcess += len(ps)
+	}
+	if s.Cap < 0 {
+		s.Cap = 0
+	}
+
+	ids := make([]string, 0, len(q.ItemStatus))
+	for id := range q.ItemStatus {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	if limit > 0 && len(ids) > limit {
+		ids = ids[:limit]
+		s.Truncated = true
+	}
+
+	s.Items = make([]ItemSnapshot, 0, len(ids))
+	for _, id := range ids {
+		s.Items = append(s.Items, q.itemSnapshot(id, now))
+	}
+	return s
+}
+
+//itemSnapshot 一个item的快照，调用者需要持有锁
+func (q *TiQueue) itemSnapshot(id string, now time.Time) ItemSnapshot {
+
+	status := q.ItemStatus[id]
+	is := ItemSnapshot{ID: id, Status: fmt.Sprintf("%04b", status)}
+
+	//低2bit是最早的那个，处理中的记录也是最早的在前面
+	ps := q.processing[id]
+	for _, bits := range []ItemStatus{status & 3, status >> 2} {
+		var st StateSnapshot
+		switch bits {
+		case Ready:
+			st.State = "ready"
+			if e, ok := q.ready[id]; ok {
+				st.Since = e.addedAt
+			}
+		case InProcess:
+			st.State = "in_process"
+			if len(ps) > 0 {
+				p := ps[0]
+				ps = ps[1:]
+				if p.retrying {
+					st.State = "retrying"
+				}
+				st.Since = p.start
+			}
+			st.Deliveries = q.deliveries[id]
+		default:
+			continue
+		}
+		if !st.Since.IsZero() {
+			st.Seconds = now.Sub(st.Since).Seconds()
+		}
+		is.States = append(is.States, st)
+	}
+	return is
+}
+
+/*
+Requeue 把处理中的item重新变为 Ready，用于处理卡住的消费者，和租约过期一样不受容量限制。
+相同的item两个都在处理中时重新加入最早的那个，原来的消费者再 Done 时完成的是重新取出的那个
+*/
+func (q *TiQueue) Requeue(id string) (err error) {
+	q.Lock()
+	defer q.Unlock()
+
+	ps := q.processing[id]
+	if len(ps) == 0 {
+		return q.opError("requeue", id, ErrNotInProcess)
+	}
+	q.requeueInflight(ps[0])
+	return nil
+}
+
+/*
+DebugHandler 查看和管理队列的 http.Handler，可以用 http.StripPrefix 挂在任意路径下：
+
+	GET  /                 队列的快照(QueueSnapshot)，limit 参数限制返回的item个数
+	POST /remove?id=...    删除等待中的item
+	POST /requeue?id=...   处理中的item重新变为 Ready，死信重新加入队列
+	POST /shutdown         关闭队列
+
+管理操作需要 SetAuth 设置的函数通过，没有设置时都拒绝
+*/
+type DebugHandler struct {
+	q *TiQueue
+
+	mu   sync.Mutex
+	auth AuthFunc
+}
+
+//NewDebugHandler 新建 DebugHandler
+func NewDebugHandler(q *TiQueue) *DebugHandler {
+	return &DebugHandler{q: q}
+}
+
+//SetAuth 设置管理操作的权限检查
+func (h *DebugHandler) SetAuth(fn AuthFunc) {
+	h.mu.Lock()
+	h.auth = fn
+	h.mu.Unlock()
+}
+
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	action := strings.Trim(r.URL.Path, "/")
+	if action == "" {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+		writeJSON(w, http.StatusOK, h.q.Snapshot(limit))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	h.mu.Lock()
+	auth := h.auth
+	h.mu.Unlock()
+	if auth == nil || !auth(r) {
+		writeJSONError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	id := r.URL.Query().Get("id")
+	switch action {
+	case "remove":
+		removed, err := h.q.Remove(id)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if !removed {
+			writeJSONError(w, http.StatusNotFound, "item is not ready")
+			return
+		}
+	case "requeue":
+		h.requeue(w, id)
+		return
+	case "shutdown":
+		h.q.ShutDown()
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown action")
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+}
+
+//requeue 处理中的item重新变为 Ready，不在处理中时尝试重新加入死信
+func (h *DebugHandler) requeue(w http.ResponseWriter, id string) {
+
+	err := h.q.Requeue(id)
+	if errors.Is(err, ErrNotInProcess) {
+		err = h.q.Redrive(id)
+	}
+	switch {
+	case err == nil:
+		writeJSON(w, http.StatusOK, map[string]bool{"ok": true})
+	case errors.Is(err, ErrNotDeadLetter):
+		writeJSONError(w, http.StatusNotFound, "item is not in process or dead lettered")
+	case errors.Is(err, ErrExceedCap), errors.Is(err, ErrItemExist), errors.Is(err, ErrClosed):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
@@ -0,0 +1,152 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-25 16:22:51
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-25 17:05:14
+ * @FilePath: \tidb\two\debug_test.go
+ */
+package two
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func getSnapshot(t *testing.T, url string) QueueSnapshot {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("status %v", resp.StatusCode)
+	}
+	var s QueueSnapshot
+	if err := json.NewDecoder(resp.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func post(t *testing.T, url string, token string) int {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, url, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.StatusCode
+}
+
+//TestDebugSnapshot 返回每个item的状态和在这个状态的时间
+func TestDebugSnapshot(t *testing.T) {
+
+	q := NewTiQueue(5)
+	q.Add(StringItem("b"))
+	q.Add(StringItem("a"))
+	q.Add(StringItem("c"))
+	item, _, _ := q.Get(false)
+	q.Add(item)
+	time.Sleep(10 * time.Millisecond)
+
+	srv := httptest.NewServer(http.StripPrefix("/debug/queue", NewDebugHandler(q)))
+	defer srv.Close()
+
+	s := getSnapshot(t, srv.URL+"/debug/queue/")
+	if s.Len != 3 || s.Cap != 5 || s.ShutDown || s.InProcess != 1 || len(s.Items) != 3 {
+		t.Fatalf("snapshot %+v", s)
+	}
+	if s.Items[0].ID != "a" || s.Items[1].ID != "b" || s.Items[2].ID != "c" {
+		t.Errorf("items %+v", s.Items)
+	}
+
+	b := s.Items[1]
+	if b.Status != "0110" || len(b.States) != 2 {
+		t.Fatalf("item %+v", b)
+	}
+	if st := b.States[0]; st.State != "in_process" || st.Deliveries != 1 || st.Seconds < 0.01 {
+		t.Errorf("state %+v", st)
+	}
+	if st := b.States[1]; st.State != "ready" || st.Since.IsZero() {
+		t.Errorf("state %+v", st)
+	}
+
+	s = getSnapshot(t, srv.URL+"/debug/queue?limit=1")
+	if len(s.Items) != 1 || !s.Truncated {
+		t.Errorf("snapshot %+v", s)
+	}
+}
+
+//TestDebugAdmin 管理操作需要通过权限检查
+func TestDebugAdmin(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.SetMaxDeliveries(1)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+	q.Add(StringItem("three"))
+	q.Get(false)
+
+	h := NewDebugHandler(q)
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	//没有设置权限检查时拒绝
+	if code := post(t, srv.URL+"/remove?id=two", ""); code != http.StatusForbidden {
+		t.Errorf("code %v", code)
+	}
+	h.SetAuth(func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret"
+	})
+	if code := post(t, srv.URL+"/remove?id=two", "wrong"); code != http.StatusForbidden {
+		t.Errorf("code %v", code)
+	}
+	if resp, _ := http.Get(srv.URL + "/remove?id=two"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("code %v", resp.StatusCode)
+	}
+
+	if code := post(t, srv.URL+"/remove?id=two", "secret"); code != http.StatusOK {
+		t.Errorf("code %v", code)
+	}
+	if code := post(t, srv.URL+"/remove?id=two", "secret"); code != http.StatusNotFound {
+		t.Errorf("code %v", code)
+	}
+
+	//处理中的item重新变为 Ready
+	if code := post(t, srv.URL+"/requeue?id=one", "secret"); code != http.StatusOK {
+		t.Errorf("code %v", code)
+	}
+	if status := q.GetItemStatus(StringItem("one")); status != Ready {
+		t.Errorf("status %04b", status)
+	}
+
+	//死信重新加入队列
+	item, _, _ := q.Get(false)
+	q.Nack(item, nil)
+	if code := post(t, srv.URL+"/requeue?id="+item.GetID(), "secret"); code != http.StatusOK {
+		t.Errorf("code %v", code)
+	}
+	if q.DeadLetters().Len() != 0 || !q.Contains(item.GetID()) {
+		t.Errorf("dead letters %v", q.DeadLetters().Len())
+	}
+	if code := post(t, srv.URL+"/requeue?id=four", "secret"); code != http.StatusNotFound {
+		t.Errorf("code %v", code)
+	}
+
+	if code := post(t, srv.URL+"/shutdown", "secret"); code != http.StatusOK {
+		t.Errorf("code %v", code)
+	}
+	if !q.ShuttingDown() || !getSnapshot(t, srv.URL).ShutDown {
+		t.Error("not shut down")
+	}
+}
@@ -0,0 +1,152 @@
+/*
+ * @Description:delaying queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 13:31:08
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 15:06:13
+ * @FilePath: \tidb\two\delaying_queue.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"three"
+)
+
+//defaultDelayPrecision 延迟队列默认的检查周期，也就是延迟的精度
+const defaultDelayPrecision = 10 * time.Millisecond
+
+//delayedItem 等待加入队列的item
+type delayedItem struct {
+	item    Itemer
+	readyAt time.Time //可以加入队列的时间
+}
+
+/*
+DelayingQueue 在TiQueue的基础上支持延迟加入队列
+延迟的item由第三题的最小堆定时器 TaskTimer 调度，到期后再走TiQueue的Add。
+同一个item(GetID相同)在等待期间多次 AddAfter，只保留最早的那次。
+*/
+type DelayingQueue struct {
+	*TiQueue
+	timer *three.TaskTimer
+
+	mu      sync.Mutex
+	pending map[string]*delayedItem //等待中的item，到期时如果不是自己说明已经被更早的替换或者被关闭清除了
+}
+
+//NewDelayingQueue 创建延迟队列，precision 为延迟的精度，小于等于0时使用默认值
+func NewDelayingQueue(maxCap int, precision time.Duration) *DelayingQueue {
+
+	if precision <= 0 {
+		precision = defaultDelayPrecision
+	}
+
+	q := &DelayingQueue{
+		TiQueue: NewTiQueue(maxCap),
+		timer:   three.NewTaskTimer(precision),
+		pending: make(map[string]*delayedItem, 0),
+	}
+	go q.timer.Run()
+
+	return q
+}
+
+//AddAfter 在d时间后把item加入队列，d小于等于0时直接加入
+func (q *DelayingQueue) AddAfter(item Itemer, d time.Duration) error {
+
+	select {
+	case <-q.done:
+		return &QueueError{Op: "add", ID: item.GetID(), Err: ErrClosed}
+	default:
+	}
+
+	if d <= 0 {
+		if err := q.TiQueue.Add(item); err != nil {
+			//加入失败时保留还在等待的，到期后再加入
+			return err
+		}
+		//取消还在等待的，否则到期后会再加入一次
+		q.mu.Lock()
+		delete(q.pending, item.GetID())
+		q.mu.Unlock()
+		return nil
+	}
+
+	return q.addAt(item, time.Now().Add(d))
+}
+
+func (q *DelayingQueue) addAt(item Itemer, readyAt time.Time) error {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	//可能刚刚被关闭了
+	select {
+	case <-q.done:
+		return &QueueError{Op: "add", ID: item.GetID(), Err: ErrClosed}
+	default:
+	}
+
+	//已经有更早的了
+	if p, ok := q.pending[item.GetID()]; ok && !readyAt.Before(p.readyAt) {
+		return nil
+	}
+
+	p := &delayedItem{item: item, readyAt: readyAt}
+	q.pending[item.GetID()] = p
+	q.timer.Add(readyAt, q.fire, p)
+	return nil
+}
+
+//fire 到期了加入队列
+func (q *DelayingQueue) fire(v interface{}) {
+
+	p := v.(*delayedItem)
+
+	q.mu.Lock()
+	if q.pending[p.item.GetID()] != p {
+		q.mu.Unlock()
+		return
+	}
+	delete(q.pending, p.item.GetID())
+	q.mu.Unlock()
+
+	//队列满了就稍后再试，其他错误(已存在，已关闭)直接丢弃
+	err := q.TiQueue.Add(p.item)
+	if errors.Is(err, ErrExceedCap) {
+		q.addAt(p.item, time.Now().Add(defaultDelayPrecision))
+	}
+}
+
+//NumDelayed 还在等待加入队列的item个数
+func (q *DelayingQueue) NumDelayed() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending)
+}
+
+//ShutDown 关闭队列，还在等待的item全部丢弃
+//TiQueue.ShutDown 会同步调用监听者，监听者可能回调 DelayingQueue，所以不能持有 q.mu。
+//先关闭再清空，addAt 在 q.mu 下检查是否关闭，关闭之后不会再有新的等待item
+func (q *DelayingQueue) ShutDown() {
+
+	q.TiQueue.ShutDown()
+
+	q.mu.Lock()
+	q.pending = make(map[string]*delayedItem, 0)
+	q.mu.Unlock()
+
+	q.timer.Stop()
+}
+
+//ShutDownWithDrain 丢弃还在等待的item，然后等待队列中的和处理中的item都被 Done
+func (q *DelayingQueue) ShutDownWithDrain(ctx context.Context) ([]string, error) {
+	q.ShutDown()
+	return q.TiQueue.ShutDownWithDrain(ctx)
+}
@@ -0,0 +1,189 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 14:40:33
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 15:06:13
+ * @FilePath: \tidb\two\delaying_queue_test.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//TestAddAfter 到期后才加入队列，按到期时间的先后被处理
+func TestAddAfter(t *testing.T) {
+
+	q := NewDelayingQueue(4, time.Millisecond)
+	defer q.ShutDown()
+
+	start := time.Now()
+	for _, v := range []struct {
+		name string
+		d    time.Duration
+	}{
+		{"c", 60 * time.Millisecond},
+		{"a", 20 * time.Millisecond},
+		{"b", 40 * time.Millisecond},
+	} {
+		err := q.AddAfter(StringItem(v.name), v.d)
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+	if q.NumDelayed() != 3 {
+		t.Errorf("delayed %v not equal 3", q.NumDelayed())
+	}
+
+	for i, want := range []string{"a", "b", "c"} {
+		item, _, err := q.Get(true)
+		if err != nil {
+			t.Error(err)
+		}
+		if item.GetID() != want {
+			t.Errorf("%v not equal %v", item.GetID(), want)
+		}
+		if d := time.Since(start); d < time.Duration(i+1)*20*time.Millisecond {
+			t.Errorf("%v ready after %v", item.GetID(), d)
+		}
+		q.Done(item)
+	}
+
+	//不需要等待的直接加入
+	err := q.AddAfter(StringItem("now"), 0)
+	if err != nil {
+		t.Error(err)
+	}
+	if q.Len() != 1 {
+		t.Errorf("len %v not equal 1", q.Len())
+	}
+
+}
+
+//TestAddAfterDedup 等待期间重复添加，只保留最早的
+func TestAddAfterDedup(t *testing.T) {
+
+	q := NewDelayingQueue(4, time.Millisecond)
+	defer q.ShutDown()
+
+	start := time.Now()
+	q.AddAfter(StringItem("one"), 200*time.Millisecond)
+	q.AddAfter(StringItem("one"), 20*time.Millisecond)
+	q.AddAfter(StringItem("one"), 100*time.Millisecond)
+
+	if q.NumDelayed() != 1 {
+		t.Errorf("delayed %v not equal 1", q.NumDelayed())
+	}
+
+	item, _, err := q.Get(true)
+	if err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d >= 100*time.Millisecond {
+		t.Errorf("%v ready after %v", item.GetID(), d)
+	}
+
+	//被替换掉的不会再加入队列
+	time.Sleep(250 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+	status := q.GetItemStatus(item)
+	if status != InProcess {
+		t.Errorf("status %v not equal InProcess", status)
+	}
+
+}
+
+//TestAddAfterImmediate d小于等于0时直接加入，并且取消还在等待的，item只会被处理一次
+func TestAddAfterImmediate(t *testing.T) {
+
+	q := NewDelayingQueue(4, time.Millisecond)
+	defer q.ShutDown()
+	q.AddAfter(StringItem("one"), 20*time.Millisecond)
+	if err := q.AddAfter(StringItem("one"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if q.NumDelayed() != 0 || q.Len() != 1 {
+		t.Errorf("delayed %v len %v", q.NumDelayed(), q.Len())
+	}
+
+	item, _, _ := q.Get(false)
+	q.Done(item)
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+}
+
+//TestAddAfterImmediateFail 直接加入失败时保留还在等待的，到期后仍然会加入
+func TestAddAfterImmediateFail(t *testing.T) {
+
+	q := NewDelayingQueue(1, time.Millisecond)
+	defer q.ShutDown()
+	q.Add(StringItem("full"))
+	q.AddAfter(StringItem("one"), 20*time.Millisecond)
+	if err := q.AddAfter(StringItem("one"), 0); !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+	if q.NumDelayed() != 1 {
+		t.Errorf("delayed %v not equal 1", q.NumDelayed())
+	}
+
+	item, _, _ := q.Get(false)
+	q.Done(item)
+	time.Sleep(50 * time.Millisecond)
+	if status := q.GetItemStatus(StringItem("one")); status != Ready {
+		t.Errorf("status %04b", status)
+	}
+}
+
+//TestAddAfterShutDown 关闭后等待中的item被丢弃
+func TestAddAfterShutDown(t *testing.T) {
+
+	q := NewDelayingQueue(4, time.Millisecond)
+	q.AddAfter(StringItem("one"), 20*time.Millisecond)
+	q.ShutDown()
+
+	if q.NumDelayed() != 0 {
+		t.Errorf("delayed %v not equal 0", q.NumDelayed())
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+
+	err := q.AddAfter(StringItem("two"), 20*time.Millisecond)
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+
+}
+
+//TestDelayingShutDownWithDrain 等待中的item被丢弃，不需要等它们到期
+func TestDelayingShutDownWithDrain(t *testing.T) {
+
+	q := NewDelayingQueue(4, time.Millisecond)
+	q.AddAfter(StringItem("one"), time.Hour)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	unfinished, err := q.ShutDownWithDrain(ctx)
+	if err != nil || len(unfinished) != 0 {
+		t.Errorf("unfinished %v err %v", unfinished, err)
+	}
+	if q.NumDelayed() != 0 {
+		t.Errorf("delayed %v not equal 0", q.NumDelayed())
+	}
+
+}
@@ -0,0 +1,112 @@
+/*
+ * @Description:durable queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 17:20:03
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-27 14:06:31
+ * @FilePath: \tidb\two\durable.go
+ */
+package two
+
+import (
+	"sort"
+	"time"
+)
+
+/*
+NewDurableTiQueue 创建持久化的队列，Add、Get、Done 在修改状态之前先追加写到 path 指向的日志文件并落盘，
+进程重启后回放日志恢复队列：崩溃时处理中的item会重新变为 Ready 排在最前面，其余的item保持原来的顺序。
+日志中的记录远多于存活的item时会自动压缩，也可以调用 Compact 手动压缩。
+codec 用于序列化item，不再使用时调用 Close 关闭日志文件。
+*/
+func NewDurableTiQueue(maxCap int, path string, codec Codec) (*TiQueue, error) {
+
+	w, recs, err := openWAL(path, codec)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := replayWAL(recs, codec)
+	if err != nil {
+		w.close()
+		return nil, err
+	}
+
+	q := NewTiQueue(maxCap)
+	now := time.Now()
+	//恢复的item不受容量限制，避免丢失
+	for _, item := range items {
+		q.ItemStatus[item.GetID()] = Ready
+		e := &entry{item: item, addedAt: now}
+		q.items.push(e)
+		q.ready[item.GetID()] = e
+	}
+
+	q.wal = w
+	//回放后立即压缩，去掉已经完成的item和最后可能写了一半的记录
+	if err = q.compact(); err != nil {
+		w.close()
+		return nil, err
+	}
+	return q, nil
+}
+
+//Compact 压缩日志，只保留当前队列中的和处理中的item
+func (q *TiQueue) Compact() error {
+	q.Lock()
+	defer q.Unlock()
+	return q.compact()
+}
+
+//Close 关闭持久化的日志文件，之后的 Add、Get、Done 都会失败，没有开启持久化时什么都不做
+func (q *TiQueue) Close() error {
+	q.Lock()
+	defer q.Unlock()
+	return q.wal.close()
+}
+
+//compact 调用者需要持有锁
+func (q *TiQueue) compact() error {
+
+	return q.wal.rewrite(func(write func(op byte, item Itemer) error) error {
+
+		//处理中的item按取出的顺序写在前面
+		var inprocess []*inflight
+		for _, p := range q.processing {
+			inprocess = append(inprocess, p...)
+		}
+		sort.Slice(inprocess, func(i, j int) bool {
+			return inprocess[i].start.Before(inprocess[j].start)
+		})
+		for _, p := range inprocess {
+			if err := write(walAdd, p.item); err != nil {
+				return err
+			}
+			if err := write(walGet, p.item); err != nil {
+				return err
+			}
+		}
+
+		var err error
+		q.items.each(func(e *entry) bool {
+			err = write(walAdd, e.item)
+			return err == nil
+		})
+		return err
+	})
+}
+
+//journal 在修改状态之前追加日志，调用者需要持有锁
+func (q *TiQueue) journal(op byte, item Itemer) error {
+	return q.wal.append(op, item)
+}
+
+//maybeCompact 在修改状态之后检查日志是否需要压缩，调用者需要持有锁
+//压缩失败不影响这次操作，原来的日志仍然完整，下次再试
+func (q *TiQueue) maybeCompact() {
+	//压缩时每个处理中的item写 walAdd 和 walGet 两条记录，Ready 的item写一条
+	if q.wal.needCompact(q.items.len() + 2*q.inflights) {
+		q.compact()
+	}
+}
@@ -0,0 +1,346 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 19:02:18
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 10:12:40
+ * @FilePath: \tidb\two\durable_test.go
+ */
+package two
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type Task struct {
+	ID   string
+	Data int
+}
+
+func (t Task) GetID() string {
+	return t.ID
+}
+
+//drain 非阻塞的取出队列中所有item
+func drain(q *TiQueue) []Itemer {
+	var res []Itemer
+	for {
+		item, _, err := q.Get(false)
+		if err != nil || item == nil {
+			return res
+		}
+		res = append(res, item)
+	}
+}
+
+//TestDurableRecover 崩溃后恢复，处理中的item重新排在最前面
+func TestDurableRecover(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, id := range []string{"a", "b", "c", "d"} {
+		err := q.Add(Task{ID: id, Data: i})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	a, _, _ := q.Get(false)
+	b, _, _ := q.Get(false)
+	q.Done(b)
+	if a.GetID() != "a" || b.GetID() != "b" {
+		t.Errorf("get %v %v", a, b)
+	}
+
+	//模拟进程崩溃，没有 ShutDown 直接关闭文件
+	q.Close()
+	err = q.Add(Task{ID: "e"})
+	if !errors.Is(err, ErrWALClosed) {
+		t.Errorf("err %v not %v", err, ErrWALClosed)
+	}
+
+	q, err = NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	items := drain(q)
+	want := []Task{{"a", 0}, {"c", 2}, {"d", 3}}
+	if len(items) != len(want) {
+		t.Fatalf("recover %v not equal %v", items, want)
+	}
+	for i := range items {
+		if items[i] != want[i] {
+			t.Errorf("%v not equal %v", items[i], want[i])
+		}
+	}
+
+}
+
+//TestDurableRecoverDuplicate 处理中的item又被加入了一次，恢复后只有一个，并且是最新的数据
+func TestDurableRecoverDuplicate(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(Task{ID: "a", Data: 1})
+	q.Add(Task{ID: "b", Data: 1})
+	q.Get(false)
+	q.Add(Task{ID: "a", Data: 2})
+	if status := q.GetItemStatus(Task{ID: "a"}); status != (Ready<<2)|InProcess {
+		t.Errorf("status %v not equal (Ready<<2)|InProcess", status)
+	}
+	q.Close()
+
+	q, err = NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	if status := q.GetItemStatus(Task{ID: "a"}); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+	items := drain(q)
+	want := []Task{{"b", 1}, {"a", 2}}
+	if len(items) != len(want) {
+		t.Fatalf("recover %v not equal %v", items, want)
+	}
+	for i := range items {
+		if items[i] != want[i] {
+			t.Errorf("%v not equal %v", items[i], want[i])
+		}
+	}
+
+}
+
+//TestDurableCompact 记录太多时自动压缩，压缩后仍然可以正确恢复
+func TestDurableCompact(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(0, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.wal.minRecords = 10
+
+	for v := 0; v < 100; v++ {
+		q.Add(IntTask(v))
+	}
+	for v := 0; v < 95; v++ {
+		item, _, _ := q.Get(false)
+		q.Done(item)
+	}
+	//96 在处理中
+	q.Get(false)
+
+	if q.wal.records > 20 {
+		t.Errorf("records %v not compact", q.wal.records)
+	}
+	q.Close()
+
+	q, err = NewDurableTiQueue(0, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	items := drain(q)
+	if len(items) != 5 {
+		t.Fatalf("recover %v not equal 5", items)
+	}
+	for i, v := range []int{95, 96, 97, 98, 99} {
+		if items[i] != IntTask(v) {
+			t.Errorf("%v not equal %v", items[i], v)
+		}
+	}
+
+	//手动压缩
+	for _, item := range items {
+		q.Done(item)
+	}
+	err = q.Compact()
+	if err != nil {
+		t.Error(err)
+	}
+	if q.wal.records != 0 {
+		t.Errorf("records %v not equal 0", q.wal.records)
+	}
+
+}
+
+//TestDurableCompactInProcess 处理中的item压缩后占两条记录，不会每次操作都压缩
+func TestDurableCompactInProcess(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(0, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	q.wal.minRecords = 10
+
+	for v := 0; v < 20; v++ {
+		q.Add(IntTask(v))
+		q.Get(false)
+	}
+
+	compacts := 0
+	records := q.wal.records
+	for v := 20; v < 40; v++ {
+		q.Add(IntTask(v))
+		item, _, _ := q.Get(false)
+		q.Done(item)
+		if q.wal.records < records {
+			compacts++
+		}
+		records = q.wal.records
+	}
+	if compacts > 1 {
+		t.Errorf("compact %v times", compacts)
+	}
+}
+
+//TestDurableTornWrite 最后一条记录只写了一半
+func TestDurableTornWrite(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(Task{ID: "a"})
+	q.Add(Task{ID: "b"})
+	q.Close()
+
+	rec := encodeRecord(walAdd, "c", []byte(`{"ID":"c","Data":0}`))
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(rec[:len(rec)-3])
+	f.Close()
+
+	q, err = NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 2 {
+		t.Errorf("len %v not equal 2", q.Len())
+	}
+
+	//压缩后写了一半的记录被去掉了，可以继续正常追加
+	q.Add(Task{ID: "c"})
+	q.Close()
+	q, err = NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	if q.Len() != 3 {
+		t.Errorf("len %v not equal 3", q.Len())
+	}
+
+}
+
+//faultyFile 可以注入错误的日志文件
+type faultyFile struct {
+	walFile
+	shortWrite  bool //只写一半然后返回错误
+	syncErr     bool
+	truncateErr bool
+}
+
+var errFault = errors.New("injected fault")
+
+func (f *faultyFile) Write(p []byte) (int, error) {
+	if f.shortWrite {
+		n, _ := f.walFile.Write(p[:len(p)/2])
+		return n, errFault
+	}
+	return f.walFile.Write(p)
+}
+
+func (f *faultyFile) Sync() error {
+	if f.syncErr {
+		return errFault
+	}
+	return f.walFile.Sync()
+}
+
+func (f *faultyFile) Truncate(size int64) error {
+	if f.truncateErr {
+		return errFault
+	}
+	return f.walFile.Truncate(size)
+}
+
+//TestDurableAppendFault 追加失败时回滚，之后追加的记录回放时不会丢失
+func TestDurableAppendFault(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(0, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &faultyFile{walFile: q.wal.f}
+	q.wal.f = f
+
+	q.Add(Task{ID: "a"})
+	f.shortWrite = true
+	if err := q.Add(Task{ID: "b"}); !errors.Is(err, errFault) {
+		t.Errorf("err %v not %v", err, errFault)
+	}
+	f.shortWrite = false
+	q.Add(Task{ID: "c"})
+	f.syncErr = true
+	if err := q.Add(Task{ID: "d"}); !errors.Is(err, errFault) {
+		t.Errorf("err %v not %v", err, errFault)
+	}
+	f.syncErr = false
+	q.Add(Task{ID: "e"})
+
+	//截断也失败了，压缩之前拒绝追加
+	f.shortWrite, f.truncateErr = true, true
+	q.Add(Task{ID: "f"})
+	f.shortWrite, f.truncateErr = false, false
+	if err := q.Add(Task{ID: "g"}); !errors.Is(err, ErrWALFailed) {
+		t.Errorf("err %v not %v", err, ErrWALFailed)
+	}
+	if err := q.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	q.Add(Task{ID: "h"})
+	q.Close()
+
+	q, err = NewDurableTiQueue(0, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	items := drain(q)
+	want := []Task{{ID: "a"}, {ID: "c"}, {ID: "e"}, {ID: "h"}}
+	if len(items) != len(want) {
+		t.Fatalf("recover %v not equal %v", items, want)
+	}
+	for i := range items {
+		if items[i] != want[i] {
+			t.Errorf("%v not equal %v", items[i], want[i])
+		}
+	}
+}
+
+func IntTask(v int) Task {
+	return Task{ID: IntItem(v).GetID(), Data: v}
+}
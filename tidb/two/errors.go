@@ -0,0 +1,60 @@
+/*
+ * @Description:errors
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-24 09:15:32
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 10:48:06
+ * @FilePath: \tidb\two\errors.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+//队列操作返回的错误都会包装成 *QueueError，用 errors.Is 判断原因
+var (
+	ErrExceedCap  = errors.New("queue is full")
+	ErrClosed     = errors.New("queue is close")
+	ErrEmpty      = errors.New("queue is empty")
+	ErrItemNotGet = errors.New("item not get") //item没有Get就Done
+	ErrItemExist  = errors.New("item exist")   //item 已经存在
+)
+
+//QueueError 队列操作失败的详细信息
+type QueueError struct {
+	Op     string     //操作，比如 add、get、done
+	ID     string     //item 的ID，和具体item无关的错误(比如队列为空)为空
+	Status ItemStatus //出错时item的状态
+	Err    error      //具体的原因，比如 ErrItemExist
+}
+
+func (e *QueueError) Error() string {
+	if e.ID == "" {
+		return fmt.Sprintf("%v: %v", e.Op, e.Err)
+	}
+	return fmt.Sprintf("%v %v (status %04b): %v", e.Op, e.ID, e.Status, e.Err)
+}
+
+func (e *QueueError) Unwrap() error {
+	return e.Err
+}
+
+/*
+opError 把操作的错误包装成 *QueueError 并带上item当前的状态，调用者需要持有锁。
+已经包装过的错误和 ctx 的错误原样返回
+*/
+func (q *TiQueue) opError(op string, id string, err error) error {
+
+	if err == nil || err == context.Canceled || err == context.DeadlineExceeded {
+		return err
+	}
+	var qe *QueueError
+	if errors.As(err, &qe) {
+		return err
+	}
+	return &QueueError{Op: op, ID: id, Status: q.ItemStatus[id], Err: err}
+}
@@ -0,0 +1,86 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-24 10:52:18
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:36:50
+ * @FilePath: \tidb\two\errors_test.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+//TestQueueError 错误带上操作、item ID 和当前的状态
+func TestQueueError(t *testing.T) {
+
+	q := NewTiQueue(2)
+	q.Add(StringItem("one"))
+	item, _, _ := q.Get(false)
+	q.Add(StringItem("one"))
+
+	err := q.Add(StringItem("one"))
+	var qe *QueueError
+	if !errors.As(err, &qe) {
+		t.Fatalf("err %v not QueueError", err)
+	}
+	if qe.Op != "add" || qe.ID != "one" || qe.Status != (Ready<<2)|InProcess || !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %+v", qe)
+	}
+
+	q.Add(StringItem("two"))
+	if err := q.Done(StringItem("two")); !errors.As(err, &qe) || qe.Op != "done" || qe.Status != Ready || !errors.Is(err, ErrItemNotGet) {
+		t.Errorf("err %v", err)
+	}
+	if err := q.Add(StringItem("three")); !errors.As(err, &qe) || qe.Status != NotExist || !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v", err)
+	}
+
+	q.Done(item)
+	q.Get(false)
+	q.Get(false)
+	if _, _, err := q.Get(false); !errors.As(err, &qe) || qe.Op != "get" || qe.ID != "" || !errors.Is(err, ErrEmpty) {
+		t.Errorf("err %v", err)
+	}
+
+	q.ShutDown()
+	if err := q.Add(StringItem("four")); !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+}
+
+//TestQueueErrorContext ctx 的错误原样返回
+func TestQueueErrorContext(t *testing.T) {
+
+	q := NewTiQueue(1)
+	q.Add(StringItem("one"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.AddContext(ctx, StringItem("two")); err != context.DeadlineExceeded {
+		t.Errorf("err %v not %v", err, context.DeadlineExceeded)
+	}
+}
+
+//TestQueueErrorStatus 状态不一致时可以同时拿到 *QueueError 和 *StatusError
+func TestQueueErrorStatus(t *testing.T) {
+
+	q := NewTiQueue(2)
+	q.Add(StringItem("one"))
+	q.setItemStatus(StringItem("one"), InProcess)
+
+	_, _, err := q.Get(false)
+	var qe *QueueError
+	var se *StatusError
+	if !errors.As(err, &qe) || qe.Op != "get" || qe.ID != "one" {
+		t.Errorf("err %v", err)
+	}
+	if !errors.As(err, &se) || !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("err %v", err)
+	}
+}
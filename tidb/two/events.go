@@ -0,0 +1,138 @@
+/*
+ * @Description:events
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-25 09:30:14
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-25 11:12:48
+ * @FilePath: \tidb\two\events.go
+ */
+package two
+
+import "time"
+
+//EventType item 状态变化的原因
+type EventType int
+
+const (
+	EventAdd        EventType = iota //Add 成功，0000 -> 0001 或者 0010 -> 0110
+	EventReject                      //Add 被拒绝，状态不变，Err 为拒绝的原因
+	EventCollapse                    //重复的item合并到了等待中的item上(AddOrMerge、提升优先级)，状态不变
+	EventGet                         //被取出，0001 -> 0010 或者 0110 -> 1010
+	EventDone                        //处理完成，状态右移2bit
+	EventRequeue                     //处理中的item重新变为 Ready(Nack、租约过期、重试)
+	EventDeadLetter                  //投递次数用完或者永久错误，放入死信，状态右移2bit，Err 为最后一次失败的原因
+	EventRemove                      //等待中的item被 Remove
+	EventShutDown                    //队列关闭，Item 为nil
+)
+
+var eventNames = [...]string{"add", "reject", "collapse", "get", "done", "requeue", "dead_letter", "remove", "shutdown"}
+
+func (t EventType) String() string {
+	if t < 0 || int(t) >= len(eventNames) {
+		return "unknown"
+	}
+	return eventNames[t]
+}
+
+//Event 一次状态变化，Old 和 New 为 ItemStatus 的2个bit编码，不存在时为 NotExist
+type Event struct {
+	Type EventType
+	Item Itemer
+	Old  ItemStatus
+	New  ItemStatus
+	Err  error
+	At   time.Time
+}
+
+/*
+Listener 监听item的状态变化。
+回调时没有持有队列的锁，可以调用队列的方法；同一个队列的事件按发生的顺序串行回调，
+可能在触发事件的 goroutine 中，也可能在其他正在回调的 goroutine 中，所以回调不能阻塞太久
+*/
+type Listener func(Event)
+
+type listenerEntry struct {
+	fn Listener
+}
+
+//AddListener 注册监听，返回取消注册的函数
+func (q *TiQueue) AddListener(fn Listener) (remove func()) {
+
+	l := &listenerEntry{fn: fn}
+	q.Lock()
+	q.listeners = append(q.listeners[:len(q.listeners):len(q.listeners)], l)
+	q.Unlock()
+
+	return func() {
+		q.Lock()
+		defer q.Unlock()
+		for i, other := range q.listeners {
+			if other == l {
+				//复制一份，正在回调的 goroutine 使用的是旧的切片
+				q.listeners = append(q.listeners[:i:i], q.listeners[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+//emit 记录item的状态变化，新的状态从 ItemStatus 中读取，解锁后回调，调用者需要持有锁
+func (q *TiQueue) emit(typ EventType, item Itemer, old ItemStatus, err error) {
+	if len(q.listeners) == 0 {
+		return
+	}
+	q.events = append(q.events, Event{
+		Type: typ,
+		Item: item,
+		Old:  old,
+		New:  q.ItemStatus[item.GetID()],
+		Err:  err,
+		At:   time.Now(),
+	})
+}
+
+/*
+Unlock 解锁，有记录下来的事件时在解锁后回调。
+已经有 goroutine 在回调时只解锁，事件由它按顺序回调，保证事件不会乱序
+*/
+func (q *TiQueue) Unlock() {
+
+	if len(q.events) == 0 || q.dispatching {
+		q.Mutex.Unlock()
+		return
+	}
+
+	q.dispatching = true
+	for len(q.events) > 0 {
+		events := q.events
+		listeners := q.listeners
+		q.events = nil
+		q.Mutex.Unlock()
+
+		q.dispatch(events, listeners)
+		q.Mutex.Lock()
+	}
+	q.dispatching = false
+	q.Mutex.Unlock()
+}
+
+//dispatch 回调事件，回调 panic 时清除回调中的标记，之后的事件仍然可以回调
+func (q *TiQueue) dispatch(events []Event, listeners []*listenerEntry) {
+
+	ok := false
+	defer func() {
+		if !ok {
+			q.Mutex.Lock()
+			q.dispatching = false
+			q.Mutex.Unlock()
+		}
+	}()
+
+	for _, e := range events {
+		for _, l := range listeners {
+			l.fn(e)
+		}
+	}
+	ok = true
+}
@@ -0,0 +1,258 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-25 11:20:36
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-27 15:21:08
+ * @FilePath: \tidb\two\events_test.go
+ */
+package two
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) listen(e Event) {
+	r.mu.Lock()
+	r.events = append(r.events, e)
+	r.mu.Unlock()
+}
+
+//take 取出记录的事件，格式为 类型 ID 原状态->新状态
+func (r *eventRecorder) take() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var s []string
+	for _, e := range r.events {
+		id := ""
+		if e.Item != nil {
+			id = e.Item.GetID()
+		}
+		s = append(s, fmt.Sprintf("%v %v %04b->%04b", e.Type, id, e.Old, e.New))
+	}
+	r.events = nil
+	return s
+}
+
+func expectEvents(t *testing.T, r *eventRecorder, want ...string) {
+	t.Helper()
+	if got := r.take(); !equalIDs(got, want) {
+		t.Errorf("events %q not equal %q", got, want)
+	}
+}
+
+//TestEventsTransitions 每次状态变化都有事件，包括重复item的状态
+func TestEventsTransitions(t *testing.T) {
+
+	q := NewTiQueue(2)
+	r := &eventRecorder{}
+	q.AddListener(r.listen)
+
+	q.Add(StringItem("one"))
+	q.Add(StringItem("one"))
+	item, _, _ := q.Get(false)
+	q.Add(StringItem("one"))
+	q.Get(false)
+	q.Done(item)
+	q.Done(item)
+	expectEvents(t, r,
+		"add one 0000->0001",
+		"reject one 0001->0001",
+		"get one 0001->0010",
+		"add one 0010->0110",
+		"get one 0110->1010",
+		"done one 1010->0010",
+		"done one 0010->0000",
+	)
+
+	q.Add(StringItem("one"))
+	q.AddOrMerge(StringItem("one"))
+	q.Add(StringItem("two"))
+	q.Add(StringItem("three"))
+	q.Remove("two")
+	expectEvents(t, r,
+		"add one 0000->0001",
+		"collapse one 0001->0001",
+		"add two 0000->0001",
+		"reject three 0000->0000",
+		"remove two 0001->0000",
+	)
+
+	q.SetMaxDeliveries(2)
+	item, _, _ = q.Get(false)
+	q.Nack(item, nil)
+	q.Get(false)
+	errFail := errors.New("fail")
+	q.Nack(item, errFail)
+	q.ShutDown()
+	q.Add(StringItem("four"))
+	expectEvents(t, r,
+		"get one 0001->0010",
+		"requeue one 0010->0001",
+		"get one 0001->0010",
+		"dead_letter one 0010->0000",
+		"shutdown  0000->0000",
+		"reject four 0000->0000",
+	)
+}
+
+//TestEventsReason 拒绝和死信的事件带上原因
+func TestEventsReason(t *testing.T) {
+
+	q := NewTiQueue(1)
+	q.SetMaxDeliveries(1)
+	r := &eventRecorder{}
+	q.AddListener(r.listen)
+
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+	item, _, _ := q.Get(false)
+	errFail := errors.New("fail")
+	q.Nack(item, errFail)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) != 4 {
+		t.Fatalf("events %v", r.events)
+	}
+	if e := r.events[1]; e.Type != EventReject || e.Err != ErrExceedCap || e.At.IsZero() {
+		t.Errorf("event %+v", e)
+	}
+	if e := r.events[3]; e.Type != EventDeadLetter || e.Err != errFail {
+		t.Errorf("event %+v", e)
+	}
+}
+
+//TestEventsListenerCallsQueue 回调时没有持有锁，可以调用队列的方法，事件仍然按顺序
+func TestEventsListenerCallsQueue(t *testing.T) {
+
+	q := NewTiQueue(0)
+	r := &eventRecorder{}
+	q.AddListener(func(e Event) {
+		//处理完成后自动加入下一个
+		if e.Type == EventDone && e.Item.GetID() == "one" {
+			q.Add(StringItem("two"))
+		}
+	})
+	remove := q.AddListener(r.listen)
+
+	q.Add(StringItem("one"))
+	item, _, _ := q.Get(false)
+	q.Done(item)
+	if q.GetItemStatus(StringItem("two")) != Ready {
+		t.Error("two not added")
+	}
+	expectEvents(t, r,
+		"add one 0000->0001",
+		"get one 0001->0010",
+		"done one 0010->0000",
+		"add two 0000->0001",
+	)
+
+	remove()
+	q.Get(false)
+	expectEvents(t, r)
+}
+
+//TestEventsListenerDelayingShutDown 监听者在关闭事件中回调 DelayingQueue 不会死锁
+func TestEventsListenerDelayingShutDown(t *testing.T) {
+
+	q := NewDelayingQueue(0, time.Millisecond)
+	q.AddAfter(StringItem("one"), time.Hour)
+	delayed := -1
+	q.AddListener(func(e Event) {
+		if e.Type == EventShutDown {
+			delayed = q.NumDelayed()
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.ShutDown()
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("shut down deadlock")
+	}
+	if delayed != 1 || q.NumDelayed() != 0 {
+		t.Errorf("delayed %v, %v after shut down", delayed, q.NumDelayed())
+	}
+}
+
+//TestEventsListenerPanic 回调 panic 后之后的事件仍然可以回调
+func TestEventsListenerPanic(t *testing.T) {
+
+	q := NewTiQueue(0)
+	r := &eventRecorder{}
+	q.AddListener(func(e Event) {
+		if e.Item.GetID() == "bad" {
+			panic("bad")
+		}
+	})
+	q.AddListener(r.listen)
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("not panic")
+			}
+		}()
+		q.Add(StringItem("bad"))
+	}()
+
+	q.Add(StringItem("good"))
+	expectEvents(t, r, "add good 0000->0001")
+}
+
+//TestEventsConcurrent 并发时同一个item的事件不会乱序
+func TestEventsConcurrent(t *testing.T) {
+
+	q := NewTiQueue(0)
+	r := &eventRecorder{}
+	q.AddListener(r.listen)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				id := strconv.Itoa(i*100 + j)
+				q.Add(StringItem(id))
+				item, _, err := q.Get(false)
+				if err != nil {
+					continue
+				}
+				q.Done(item)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := make(map[string]ItemStatus)
+	for _, e := range r.events {
+		id := e.Item.GetID()
+		if e.Old != last[id] {
+			t.Fatalf("%v %v old %04b not equal last %04b", e.Type, id, e.Old, last[id])
+		}
+		last[id] = e.New
+	}
+	if len(r.events) != 2400 {
+		t.Errorf("events %v not equal 2400", len(r.events))
+	}
+}
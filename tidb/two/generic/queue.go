@@ -0,0 +1,248 @@
+/*
+ * @Description:generic queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 17:40:21
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-27 16:10:25
+ * @FilePath: \tidb\two\generic\queue.go
+ */
+package generic
+
+import (
+	"fmt"
+	"sync"
+
+	"two"
+)
+
+/*
+Queue 泛型版本的 TiQueue，去重和处理中的语义与 TiQueue 完全一致，
+item 的状态同样用 two.ItemStatus 的2个bit编码，具体见 two.TiQueue 的注释。
+不同的是 Get 返回的就是 T 本身，不需要再做类型断言，唯一标识也不要求是 string，
+只要是 comparable 的类型都可以。
+*/
+type Queue[K comparable, T any] struct {
+	maxCap   int //队列最大的item数量，小于等于0表示不限制
+	items    []T //Ready 状态的item，按加入的顺序排列
+	mu       sync.Mutex
+	status   map[K]two.ItemStatus //记录item的状态
+	key      func(T) K            //获取item的唯一标识 用于去重
+	done     chan struct{}        //标记是否已经关闭
+	once     sync.Once
+	notEmpty chan struct{} //有阻塞的 Get 在等待item时才创建，Add 后关闭它来唤醒等待者
+}
+
+//Keyer 自带唯一标识的item
+type Keyer[K comparable] interface {
+	GetID() K
+}
+
+//和 two 中的是同一个错误，可以用 errors.Is 统一判断
+var (
+	ErrExceedCap  = two.ErrExceedCap
+	ErrClosed     = two.ErrClosed
+	ErrEmpty      = two.ErrEmpty
+	ErrItemNotGet = two.ErrItemNotGet //item没有Get就Done
+	ErrItemExist  = two.ErrItemExist  //item 已经存在
+
+	ErrStatusNotFound = two.ErrStatusNotFound //队列中的item没有状态
+	ErrInvalidStatus  = two.ErrInvalidStatus  //队列中的item状态不对
+)
+
+//New 队列初始化，key 用于获取item的唯一标识，maxCap 小于等于0时不限制容量，和 two.NewTiQueue 一致
+func New[K comparable, T any](maxCap int, key func(T) K) *Queue[K, T] {
+
+	return &Queue[K, T]{
+		maxCap: maxCap,
+		status: make(map[K]two.ItemStatus, 0),
+		key:    key,
+		done:   make(chan struct{}, 0),
+	}
+}
+
+//NewKeyed 队列初始化，item 自身通过 GetID 提供唯一标识
+func NewKeyed[K comparable, T Keyer[K]](maxCap int) *Queue[K, T] {
+	return New[K, T](maxCap, func(item T) K {
+		return item.GetID()
+	})
+}
+
+//Add 添加item 到队列
+func (q *Queue[K, T]) Add(item T) error {
+
+	//快速判定，因为队列不可能从关闭变为开启
+	select {
+	case <-q.done:
+		return ErrClosed
+	default:
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	//加锁前可能刚好被关闭了
+	select {
+	case <-q.done:
+		return ErrClosed
+	default:
+	}
+
+	//容量在锁内检查，避免并发的 Add 同时通过检查
+	if q.maxCap > 0 && len(q.items) >= q.maxCap {
+		return ErrExceedCap
+	}
+
+	id := q.key(item)
+	status, ok := q.status[id]
+	if !ok {
+		//队列中不存在，就可以直接添加
+		q.push(item)
+		q.status[id] = two.Ready
+		return nil
+	}
+
+	//已经处理中，而且此刻只有一个相同的item
+	if status == two.InProcess {
+		q.push(item)
+		q.status[id] = (two.Ready << 2) | two.InProcess
+		return nil
+	}
+
+	//其他情况下都不可以再进行添加了
+	return ErrItemExist
+}
+
+//push 加入队尾并唤醒等待的 Get，调用者需要持有锁
+func (q *Queue[K, T]) push(item T) {
+	q.items = append(q.items, item)
+	if q.notEmpty != nil {
+		close(q.notEmpty)
+		q.notEmpty = nil
+	}
+}
+
+//Get 从队列中获取item，block 标记是否阻塞读
+//队列关闭并且已经没有item时 shutdown 为 true
+//队头item的状态不对时丢弃它并返回包装了 *two.StatusError 的错误，后面的item不受影响
+func (q *Queue[K, T]) Get(block bool) (item T, shutdown bool, err error) {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for len(q.items) == 0 {
+		select {
+		case <-q.done:
+			//队列已经关闭，并且item都被取走了
+			shutdown = true
+			return
+		default:
+		}
+
+		if !block {
+			err = ErrEmpty
+			return
+		}
+
+		if q.notEmpty == nil {
+			q.notEmpty = make(chan struct{}, 0)
+		}
+		notEmpty := q.notEmpty
+		q.mu.Unlock()
+		select {
+		case <-notEmpty:
+		case <-q.done:
+		}
+		q.mu.Lock()
+	}
+
+	item = q.items[0]
+	var zero T
+	q.items[0] = zero //避免内存泄漏
+	q.items = q.items[1:]
+
+	id := q.key(item)
+	status, ok := q.status[id]
+	if !ok {
+		return zero, false, &two.StatusError{ID: fmt.Sprint(id), Err: ErrStatusNotFound, Detail: "queued"}
+	}
+
+	if status == two.Ready {
+		q.status[id] = two.InProcess
+		return
+	}
+
+	//两个相同item的情况 最早的肯定是 InProcess ，最新的肯定是 Ready
+	if status == (two.Ready<<2)|two.InProcess {
+		q.status[id] = (two.InProcess << 2) | two.InProcess
+		return
+	}
+
+	return zero, false, &two.StatusError{ID: fmt.Sprint(id), Status: status, Err: ErrInvalidStatus, Detail: "queued"}
+}
+
+//Len 获取Ready 状态的数据个数
+func (q *Queue[K, T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.items)
+}
+
+//Done 表示item处理完成了
+func (q *Queue[K, T]) Done(item T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	id := q.key(item)
+	status, ok := q.status[id]
+	if !ok {
+		//幂等处理
+		return nil
+	}
+
+	//如果没有Get就Done 了
+	if status == two.Ready {
+		return ErrItemNotGet
+	}
+
+	status = status >> 2
+	if status == two.NotExist {
+		delete(q.status, id)
+	} else {
+		q.status[id] = status
+	}
+	return nil
+}
+
+//ShutDown 关闭
+func (q *Queue[K, T]) ShutDown() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.once.Do(func() {
+		close(q.done)
+	})
+}
+
+//ShuttingDown 判断是否在关闭中，已经完全关闭或者还没有关闭都返回false
+func (q *Queue[K, T]) ShuttingDown() bool {
+	select {
+	case <-q.done:
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return len(q.status) != 0
+	default:
+		return false
+	}
+}
+
+//GetCloseNotify 用于接收关闭通知
+func (q *Queue[K, T]) GetCloseNotify() <-chan struct{} {
+	return q.done
+}
+
+//GetItemStatus 获取item状态
+func (q *Queue[K, T]) GetItemStatus(item T) two.ItemStatus {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.status[q.key(item)]
+}
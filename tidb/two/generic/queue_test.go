@@ -0,0 +1,263 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 18:40:02
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-27 16:10:25
+ * @FilePath: \tidb\two\generic\queue_test.go
+ */
+package generic
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"two"
+)
+
+type Job struct {
+	ID   int
+	Name string
+}
+
+func (j Job) GetID() int {
+	return j.ID
+}
+
+func TestKeyedQueue(t *testing.T) {
+
+	q := NewKeyed[int, Job](2)
+	for i, name := range []string{"a", "b"} {
+		err := q.Add(Job{ID: i, Name: name})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	//不需要类型断言
+	job, _, err := q.Get(true)
+	if err != nil {
+		t.Error(err)
+	}
+	if job.ID != 0 || job.Name != "a" {
+		t.Errorf("job %v not equal %v", job, Job{ID: 0, Name: "a"})
+	}
+
+	//重复添加
+	err = q.Add(Job{ID: 1, Name: "c"})
+	if !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+	if status := q.GetItemStatus(job); status != two.InProcess {
+		t.Errorf("%v status %v not equal InProcess", job, status)
+	}
+
+	//处理中可以再添加一个
+	err = q.Add(Job{ID: 0, Name: "d"})
+	if err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(job); status != (two.Ready<<2)|two.InProcess {
+		t.Errorf("%v status %v not equal (Ready<<2)|InProcess", job, status)
+	}
+
+	err = q.Add(Job{ID: 2, Name: "e"})
+	if !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+
+	q.Done(job)
+	if status := q.GetItemStatus(job); status != two.Ready {
+		t.Errorf("%v status %v not equal Ready", job, status)
+	}
+
+	q.ShutDown()
+	if !q.ShuttingDown() {
+		t.Errorf("shutingdown %v not %v", false, true)
+	}
+
+	names := ""
+	for {
+		job, shutdown, err := q.Get(false)
+		if shutdown {
+			break
+		}
+		if err != nil {
+			t.Error(err)
+			break
+		}
+		names += job.Name
+		q.Done(job)
+	}
+	if names != "bd" {
+		t.Errorf("names %v not equal %v", names, "bd")
+	}
+	if q.ShuttingDown() {
+		t.Errorf("shutingdown %v not %v", true, false)
+	}
+
+}
+
+//TestKeyFunc 通过key函数获取唯一标识
+func TestKeyFunc(t *testing.T) {
+
+	q := New[string](10, func(p *Job) string { return p.Name })
+	err := q.Add(&Job{ID: 1, Name: "a"})
+	if err != nil {
+		t.Error(err)
+	}
+	err = q.Add(&Job{ID: 2, Name: "a"})
+	if !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+
+	_, _, err = q.Get(false)
+	if err != nil {
+		t.Error(err)
+	}
+	_, _, err = q.Get(false)
+	if !errors.Is(err, ErrEmpty) {
+		t.Errorf("err %v not %v", err, ErrEmpty)
+	}
+
+	//没有Get就Done
+	q.Add(&Job{ID: 3, Name: "b"})
+	err = q.Done(&Job{ID: 3, Name: "b"})
+	if !errors.Is(err, ErrItemNotGet) {
+		t.Errorf("err %v not %v", err, ErrItemNotGet)
+	}
+
+}
+
+//TestProduceOnce 多个消费者情况下，item只会处理一次
+func TestProduceOnce(t *testing.T) {
+
+	q := NewKeyed[int, Job](1000)
+	for v := 0; v < 1000; v++ {
+		err := q.Add(Job{ID: v})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	q.ShutDown()
+
+	mx := sync.Mutex{}
+	seen := make(map[int]struct{}, 0)
+	wg := sync.WaitGroup{}
+	wg.Add(8)
+	for i := 0; i < 8; i++ {
+		go func() {
+			for {
+				job, shutdown, _ := q.Get(true)
+				if shutdown {
+					break
+				}
+				mx.Lock()
+				if _, ok := seen[job.ID]; ok {
+					t.Errorf("%v has produce", job.ID)
+				}
+				seen[job.ID] = struct{}{}
+				mx.Unlock()
+				q.Done(job)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 1000 {
+		t.Errorf("produce %v not equal %v", len(seen), 1000)
+	}
+
+}
+
+//TestUnbounded maxCap 为0时不限制容量，和 two.NewTiQueue(0) 一致
+func TestUnbounded(t *testing.T) {
+
+	q := NewKeyed[int, Job](0)
+	for v := 0; v < 100; v++ {
+		if err := q.Add(Job{ID: v}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if q.Len() != 100 {
+		t.Errorf("len %v not equal 100", q.Len())
+	}
+
+	//阻塞的 Get 等到新加入的item
+	for v := 0; v < 100; v++ {
+		q.Get(false)
+	}
+	got := make(chan Job, 1)
+	go func() {
+		job, _, _ := q.Get(true)
+		got <- job
+	}()
+	time.Sleep(20 * time.Millisecond)
+	q.Add(Job{ID: 100})
+	if job := <-got; job.ID != 100 {
+		t.Errorf("job %v", job)
+	}
+
+	//关闭后阻塞的 Get 返回 shutdown
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.ShutDown()
+	}()
+	if _, shutdown, err := q.Get(true); !shutdown || err != nil {
+		t.Errorf("shutdown %v err %v", shutdown, err)
+	}
+}
+
+//TestAddConcurrentCap 并发 Add 不会超过容量，队列满时 Add 不阻塞
+func TestAddConcurrentCap(t *testing.T) {
+
+	q := NewKeyed[int, Job](10)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for v := 0; v < 100; v++ {
+				q.Add(Job{ID: i*100 + v})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if q.Len() != 10 {
+		t.Errorf("len %v not equal 10", q.Len())
+	}
+	if err := q.Add(Job{ID: 1000}); !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+}
+
+//TestGetStatusError 状态不对时返回错误而不是 panic，丢弃出错的item
+func TestGetStatusError(t *testing.T) {
+
+	q := NewKeyed[int, Job](0)
+	q.Add(Job{ID: 1})
+	q.Add(Job{ID: 2})
+	q.Add(Job{ID: 3})
+	delete(q.status, 1)
+	q.status[2] = two.InProcess
+
+	var se *two.StatusError
+	_, _, err := q.Get(false)
+	if !errors.Is(err, ErrStatusNotFound) || !errors.As(err, &se) || se.ID != "1" {
+		t.Errorf("err %v not %v", err, ErrStatusNotFound)
+	}
+	_, _, err = q.Get(false)
+	if !errors.Is(err, ErrInvalidStatus) || !errors.As(err, &se) || se.Status != two.InProcess {
+		t.Errorf("err %v not %v", err, ErrInvalidStatus)
+	}
+
+	job, _, err := q.Get(false)
+	if err != nil || job.ID != 3 {
+		t.Errorf("job %v err %v", job, err)
+	}
+}
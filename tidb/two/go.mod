@@ -1,3 +1,7 @@
 module two
 
-go 1.15
+go 1.18
+
+require three v0.0.0
+
+replace three => ../three
@@ -0,0 +1,614 @@
+/*
+ * @Description:http queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-26 09:40:12
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 11:20:05
+ * @FilePath: \tidb\two\http_queue.go
+ */
+package two
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//defaultPollWait 长轮询的 Get 一次最多等待多久
+const defaultPollWait = 30 * time.Second
+
+//defaultLeaseTTL HTTPServer 取出的item默认的租约，客户端崩溃后最多这么久item重新变为 Ready
+const defaultLeaseTTL = 5 * time.Minute
+
+//defaultMaxBodyBytes HTTPServer 默认的请求 body 最大长度
+const defaultMaxBodyBytes = 4 << 20
+
+//wireErrors 网络传输时错误的原因和对应的 sentinel 错误，客户端据此还原错误
+var wireErrors = []struct {
+	reason string
+	err    error
+	code   int //HTTP 状态码
+}{
+	{"exceed_cap", ErrExceedCap, http.StatusServiceUnavailable},
+	{"exist", ErrItemExist, http.StatusConflict},
+	{"closed", ErrClosed, http.StatusGone},
+	{"empty", ErrEmpty, http.StatusNotFound},
+	{"not_get", ErrItemNotGet, http.StatusConflict},
+	{"lease_expired", ErrLeaseExpired, http.StatusConflict},
+}
+
+//wireError 服务端返回的错误
+type wireError struct {
+	Op     string     `json:"op,omitempty"`
+	ID     string     `json:"id,omitempty"`
+	Status ItemStatus `json:"status,omitempty"`
+	Reason string     `json:"reason,omitempty"` //wireErrors 中的原因，其他错误为空
+	Error  string     `json:"error"`
+}
+
+//toWire 把错误转换成传输的格式和 HTTP 状态码
+func toWire(err error) (wireError, int) {
+
+	we := wireError{Error: err.Error()}
+	var qe *QueueError
+	if errors.As(err, &qe) {
+		we.Op, we.ID, we.Status, we.Error = qe.Op, qe.ID, qe.Status, qe.Err.Error()
+	}
+	for _, v := range wireErrors {
+		if errors.Is(err, v.err) {
+			we.Reason = v.reason
+			return we, v.code
+		}
+	}
+	return we, http.StatusInternalServerError
+}
+
+//fromWire 还原服务端返回的错误，已知的原因还原成对应的 sentinel 错误，都包装成 *QueueError
+func fromWire(we wireError, op string) error {
+
+	err := errors.New(we.Error)
+	for _, v := range wireErrors {
+		if v.reason == we.Reason {
+			err = v.err
+			break
+		}
+	}
+	if we.Op != "" {
+		op = we.Op
+	}
+	return &QueueError{Op: op, ID: we.ID, Status: we.Status, Err: err}
+}
+
+/*
+HTTPServer 把 TiQueue 通过 HTTP 提供给其他进程使用，item 的内容用 Codec 序列化后作为请求和响应的body：
+
+	POST /add                         body 为item，加入队列
+	POST /get?wait=10s&ttl=1m         长轮询获取item，wait 为0时不等待，ttl 为租约的时间，不传时使用 SetLeaseTTL 的值，为0时不设置租约。
+	                                  200 body 为item，X-Queue-Lease 为租约的标识，X-Queue-Lease-Expiry 为到期时间(RFC3339)，
+	                                  204 表示等待超时，队列关闭并且没有item时 204 并且 X-Queue-Shutdown 为 true
+	POST /extend?id=x&lease=t&ttl=1m  续约，{"expiry": "..."}
+	POST /done                        body 为item，处理完成
+	POST /done?id=x&lease=t           租约对应的item处理完成，租约已经过期时返回 lease_expired
+	GET  /len                         {"len": n}
+	POST /shutdown                    关闭队列
+	GET  /shutting_down               {"shutting_down": true}
+
+取出的item带有租约，客户端崩溃后没有 done 的item在租约到期后重新变为 Ready，不会一直处于处理中。
+失败时返回 JSON 格式的错误，去重和处理中的语义都由服务端的 TiQueue 保证
+*/
+type HTTPServer struct {
+	q        *TiQueue
+	codec    Codec
+	maxWait  time.Duration
+	leaseTTL time.Duration //get 默认的租约，小于等于0表示不设置租约
+	maxBody  int64         //请求 body 的最大长度，小于等于0表示不限制
+	routes   map[string]func(w http.ResponseWriter, r *http.Request)
+	readOnly map[string]bool //GET 请求可以访问的路径
+}
+
+//NewHTTPServer 新建 HTTPServer，可以用 http.StripPrefix 挂在任意路径下
+func NewHTTPServer(q *TiQueue, codec Codec) *HTTPServer {
+
+	s := &HTTPServer{
+		q:        q,
+		codec:    codec,
+		maxWait:  defaultPollWait,
+		leaseTTL: defaultLeaseTTL,
+		maxBody:  defaultMaxBodyBytes,
+		readOnly: map[string]bool{"len": true, "shutting_down": true},
+	}
+	s.routes = map[string]func(w http.ResponseWriter, r *http.Request){
+		"add":           s.add,
+		"get":           s.get,
+		"extend":        s.extend,
+		"done":          s.done,
+		"len":           s.len,
+		"shutdown":      s.shutdown,
+		"shutting_down": s.shuttingDown,
+	}
+	return s
+}
+
+//SetMaxWait 设置长轮询最多等待多久，客户端要求的等待时间超过它时按它等待，需要在开始服务之前调用
+func (s *HTTPServer) SetMaxWait(d time.Duration) {
+	s.maxWait = d
+}
+
+//SetLeaseTTL 设置客户端没有指定时 get 的租约，小于等于0时不设置租约，需要在开始服务之前调用
+func (s *HTTPServer) SetLeaseTTL(d time.Duration) {
+	s.leaseTTL = d
+}
+
+//SetMaxBodyBytes 设置请求 body 的最大长度，超过时返回 413，小于等于0时不限制，需要在开始服务之前调用
+func (s *HTTPServer) SetMaxBodyBytes(n int64) {
+	s.maxBody = n
+}
+
+func (s *HTTPServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	name := strings.Trim(r.URL.Path, "/")
+	route, ok := s.routes[name]
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "unknown path")
+		return
+	}
+	if r.Method != http.MethodPost && !(s.readOnly[name] && r.Method == http.MethodGet) {
+		writeJSONError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	route(w, r)
+}
+
+func (s *HTTPServer) writeError(w http.ResponseWriter, err error) {
+	we, code := toWire(err)
+	writeJSON(w, code, we)
+}
+
+//readItem 读取body中的item，body 超过最大长度时返回 413
+func (s *HTTPServer) readItem(w http.ResponseWriter, r *http.Request) (Itemer, bool) {
+
+	body := r.Body
+	if s.maxBody > 0 {
+		body = http.MaxBytesReader(w, body, s.maxBody)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil && s.maxBody > 0 && int64(len(data)) >= s.maxBody {
+		writeJSON(w, http.StatusRequestEntityTooLarge, wireError{Error: err.Error()})
+		return nil, false
+	}
+	if err == nil {
+		var item Itemer
+		if item, err = s.codec.Decode(data); err == nil {
+			return item, true
+		}
+	}
+	writeJSON(w, http.StatusBadRequest, wireError{Error: err.Error()})
+	return nil, false
+}
+
+//duration 读取 query 中的时间，没有传时返回 def，格式不对时返回 400 并且 ok 为 false
+func duration(w http.ResponseWriter, r *http.Request, name string, def time.Duration) (time.Duration, bool) {
+
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return def, true
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, wireError{Error: err.Error()})
+		return 0, false
+	}
+	return d, true
+}
+
+//findLease 根据 query 中的 id 和 lease 找到租约，租约不存在时返回 lease_expired
+func (s *HTTPServer) findLease(w http.ResponseWriter, r *http.Request) (*Lease, bool) {
+
+	id, token := r.URL.Query().Get("id"), r.URL.Query().Get("lease")
+	if id == "" || token == "" {
+		writeJSON(w, http.StatusBadRequest, wireError{Error: "invalid lease"})
+		return nil, false
+	}
+	l := s.q.findLease(id, token)
+	if l == nil {
+		s.writeError(w, &QueueError{Op: "lease", ID: id, Err: ErrLeaseExpired})
+		return nil, false
+	}
+	return l, true
+}
+
+func (s *HTTPServer) add(w http.ResponseWriter, r *http.Request) {
+
+	item, ok := s.readItem(w, r)
+	if !ok {
+		return
+	}
+	if err := s.q.Add(item); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) get(w http.ResponseWriter, r *http.Request) {
+
+	wait, ok := duration(w, r, "wait", 0)
+	if !ok {
+		return
+	}
+	if wait > s.maxWait {
+		wait = s.maxWait
+	}
+	ttl, ok := duration(w, r, "ttl", s.leaseTTL)
+	if !ok {
+		return
+	}
+
+	var lease *Lease
+	var shutdown bool
+	var err error
+	if wait <= 0 {
+		lease, shutdown, err = s.q.getLease(context.Background(), false, ttl)
+	} else {
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		lease, shutdown, err = s.q.getLease(ctx, true, ttl)
+		cancel()
+		//等待超时
+		if err == context.DeadlineExceeded {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+	}
+
+	switch {
+	case shutdown:
+		w.Header().Set("X-Queue-Shutdown", "true")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case err != nil:
+		s.writeError(w, err)
+		return
+	}
+
+	//客户端已经断开了，这次投递重新加入队列，不算投递次数
+	if r.Context().Err() != nil {
+		s.q.releaseLease(lease)
+		return
+	}
+	data, err := s.codec.Encode(lease.Item)
+	if err == nil {
+		err = s.q.issueToken(lease)
+	}
+	if err != nil {
+		s.q.releaseLease(lease)
+		s.writeError(w, err)
+		return
+	}
+
+	w.Header().Set("X-Queue-Lease", lease.token)
+	if !lease.Expiry.IsZero() {
+		w.Header().Set("X-Queue-Lease-Expiry", lease.Expiry.Format(time.RFC3339Nano))
+	}
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (s *HTTPServer) extend(w http.ResponseWriter, r *http.Request) {
+
+	ttl, ok := duration(w, r, "ttl", 0)
+	if !ok {
+		return
+	}
+	if ttl <= 0 {
+		writeJSON(w, http.StatusBadRequest, wireError{Error: "invalid ttl"})
+		return
+	}
+	l, ok := s.findLease(w, r)
+	if !ok {
+		return
+	}
+	if err := s.q.ExtendLease(l, ttl); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]time.Time{"expiry": l.Expiry})
+}
+
+func (s *HTTPServer) done(w http.ResponseWriter, r *http.Request) {
+
+	if r.URL.Query().Get("lease") != "" {
+		l, ok := s.findLease(w, r)
+		if !ok {
+			return
+		}
+		if err := s.q.DoneLease(l); err != nil {
+			s.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	item, ok := s.readItem(w, r)
+	if !ok {
+		return
+	}
+	if err := s.q.Done(item); err != nil {
+		s.writeError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) len(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]int{"len": s.q.Len()})
+}
+
+func (s *HTTPServer) shutdown(w http.ResponseWriter, r *http.Request) {
+	s.q.ShutDown()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *HTTPServer) shuttingDown(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]bool{"shutting_down": s.q.ShuttingDown()})
+}
+
+var _ Queue = (*HTTPClient)(nil)
+
+/*
+HTTPClient 访问 HTTPServer 的客户端，实现了 Queue。
+服务端返回的错误还原成 *QueueError，可以用 errors.Is 判断 ErrExceedCap 等原因。
+Len、ShutDown 和 ShuttingDown 没有返回错误，请求失败时分别返回0、忽略和 false，需要区分时使用 LenContext 等方法。
+Get 取到的item带有服务端默认的租约，处理时间可能超过租约时使用 GetWithLease、ExtendLease 和 DoneLease
+*/
+type HTTPClient struct {
+	base     string
+	codec    Codec
+	client   *http.Client
+	pollWait time.Duration
+}
+
+//NewHTTPClient 新建客户端，baseURL 为 HTTPServer 挂载的地址
+func NewHTTPClient(baseURL string, codec Codec) *HTTPClient {
+	return &HTTPClient{
+		base:     strings.TrimRight(baseURL, "/"),
+		codec:    codec,
+		client:   http.DefaultClient,
+		pollWait: defaultPollWait,
+	}
+}
+
+//SetHTTPClient 设置发送请求的 http.Client，不要设置比长轮询等待时间还短的超时
+func (c *HTTPClient) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+//SetPollWait 设置阻塞的 Get 每次长轮询等待多久，超过服务端的 SetMaxWait 时按服务端的等待
+func (c *HTTPClient) SetPollWait(d time.Duration) {
+	c.pollWait = d
+}
+
+//do 发送请求，返回状态码、响应头和body，服务端返回错误时还原错误
+func (c *HTTPClient) do(ctx context.Context, method string, path string, query url.Values, body []byte) (int, http.Header, []byte, error) {
+
+	u := c.base + "/" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if resp.StatusCode >= 300 {
+		var we wireError
+		if json.Unmarshal(data, &we) != nil || we.Error == "" {
+			return 0, nil, nil, fmt.Errorf("%v %v: %v", method, path, resp.Status)
+		}
+		return 0, nil, nil, fromWire(we, path)
+	}
+	return resp.StatusCode, resp.Header, data, nil
+}
+
+//Add 添加item 到队列
+func (c *HTTPClient) Add(item Itemer) error {
+	return c.AddContext(context.Background(), item)
+}
+
+//AddContext 添加item 到队列，队列满了直接返回错误
+func (c *HTTPClient) AddContext(ctx context.Context, item Itemer) error {
+	data, err := c.codec.Encode(item)
+	if err != nil {
+		return err
+	}
+	_, _, _, err = c.do(ctx, http.MethodPost, "add", nil, data)
+	return err
+}
+
+//Get 从队列中获取item，block 为 true 时长轮询直到获取到item或者队列关闭
+func (c *HTTPClient) Get(block bool) (item Itemer, shutdown bool, err error) {
+	l, shutdown, err := c.getLease(context.Background(), block, nil)
+	if l != nil {
+		item = l.Item
+	}
+	return
+}
+
+//GetContext 长轮询获取item，直到获取到item、队列关闭并且没有item或者ctx结束
+func (c *HTTPClient) GetContext(ctx context.Context) (item Itemer, shutdown bool, err error) {
+	l, shutdown, err := c.getLease(ctx, true, nil)
+	if l != nil {
+		item = l.Item
+	}
+	return
+}
+
+//GetWithLease 获取item并设置租约，block 为 true 时长轮询，ttl 小于等于0时不设置租约
+func (c *HTTPClient) GetWithLease(block bool, ttl time.Duration) (lease *Lease, shutdown bool, err error) {
+	return c.GetWithLeaseContext(context.Background(), block, ttl)
+}
+
+//GetWithLeaseContext 获取item并设置租约，block 为 true 时长轮询直到获取到item、队列关闭并且没有item或者ctx结束
+func (c *HTTPClient) GetWithLeaseContext(ctx context.Context, block bool, ttl time.Duration) (lease *Lease, shutdown bool, err error) {
+	if ttl < 0 {
+		ttl = 0
+	}
+	return c.getLease(ctx, block, &ttl)
+}
+
+//getLease ttl 为nil时使用服务端默认的租约
+func (c *HTTPClient) getLease(ctx context.Context, block bool, ttl *time.Duration) (*Lease, bool, error) {
+	if !block {
+		return c.poll(ctx, 0, ttl)
+	}
+	for {
+		l, shutdown, err := c.poll(ctx, c.pollWait, ttl)
+		if l != nil || shutdown || err != nil {
+			return l, shutdown, err
+		}
+	}
+}
+
+//poll 获取一次，wait 为服务端等待的时间，等待超时时都返回零值
+func (c *HTTPClient) poll(ctx context.Context, wait time.Duration, ttl *time.Duration) (*Lease, bool, error) {
+
+	query := url.Values{"wait": {wait.String()}}
+	if ttl != nil {
+		query.Set("ttl", ttl.String())
+	}
+	code, header, data, err := c.do(ctx, http.MethodPost, "get", query, nil)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, false, ctx.Err()
+		}
+		return nil, false, err
+	}
+	if code == http.StatusNoContent {
+		return nil, header.Get("X-Queue-Shutdown") == "true", nil
+	}
+	item, err := c.codec.Decode(data)
+	if err != nil {
+		return nil, false, err
+	}
+	l := &Lease{Item: item, token: header.Get("X-Queue-Lease")}
+	if v := header.Get("X-Queue-Lease-Expiry"); v != "" {
+		l.Expiry, _ = time.Parse(time.RFC3339Nano, v)
+	}
+	return l, false, nil
+}
+
+//leaseQuery 租约在服务端的标识
+func leaseQuery(l *Lease) url.Values {
+	return url.Values{"id": {l.Item.GetID()}, "lease": {l.token}}
+}
+
+//ExtendLease 续约，租约在 ttl 之后到期。租约已经过期或者item已经处理完成时返回 ErrLeaseExpired
+func (c *HTTPClient) ExtendLease(l *Lease, ttl time.Duration) error {
+	return c.ExtendLeaseContext(context.Background(), l, ttl)
+}
+
+//ExtendLeaseContext 续约，租约在 ttl 之后到期
+func (c *HTTPClient) ExtendLeaseContext(ctx context.Context, l *Lease, ttl time.Duration) error {
+	query := leaseQuery(l)
+	query.Set("ttl", ttl.String())
+	_, _, data, err := c.do(ctx, http.MethodPost, "extend", query, nil)
+	if err != nil {
+		return err
+	}
+	var v struct {
+		Expiry time.Time `json:"expiry"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	l.Expiry = v.Expiry
+	return nil
+}
+
+//DoneLease 租约对应的item处理完成。租约已经过期时返回 ErrLeaseExpired，这时item可能已经被其他消费者处理了
+func (c *HTTPClient) DoneLease(l *Lease) error {
+	return c.DoneLeaseContext(context.Background(), l)
+}
+
+//DoneLeaseContext 租约对应的item处理完成
+func (c *HTTPClient) DoneLeaseContext(ctx context.Context, l *Lease) error {
+	_, _, _, err := c.do(ctx, http.MethodPost, "done", leaseQuery(l), nil)
+	return err
+}
+
+//Done 表示item处理完成了
+func (c *HTTPClient) Done(item Itemer) error {
+	return c.DoneContext(context.Background(), item)
+}
+
+//DoneContext 表示item处理完成了
+func (c *HTTPClient) DoneContext(ctx context.Context, item Itemer) error {
+	data, err := c.codec.Encode(item)
+	if err != nil {
+		return err
+	}
+	_, _, _, err = c.do(ctx, http.MethodPost, "done", nil, data)
+	return err
+}
+
+//Len 队列中 Ready 状态的item个数，请求失败时返回0
+func (c *HTTPClient) Len() int {
+	n, _ := c.LenContext(context.Background())
+	return n
+}
+
+//LenContext 队列中 Ready 状态的item个数
+func (c *HTTPClient) LenContext(ctx context.Context) (int, error) {
+	_, _, data, err := c.do(ctx, http.MethodGet, "len", nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	var v struct {
+		Len int `json:"len"`
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return 0, err
+	}
+	return v.Len, nil
+}
+
+//ShutDown 关闭服务端的队列，请求失败时忽略
+func (c *HTTPClient) ShutDown() {
+	c.ShutDownContext(context.Background())
+}
+
+//ShutDownContext 关闭服务端的队列
+func (c *HTTPClient) ShutDownContext(ctx context.Context) error {
+	_, _, _, err := c.do(ctx, http.MethodPost, "shutdown", nil, nil)
+	return err
+}
+
+//ShuttingDown 服务端的队列是否已经关闭，请求失败时返回 false
+func (c *HTTPClient) ShuttingDown() bool {
+	_, _, data, err := c.do(context.Background(), http.MethodGet, "shutting_down", nil, nil)
+	if err != nil {
+		return false
+	}
+	var v struct {
+		ShuttingDown bool `json:"shutting_down"`
+	}
+	json.Unmarshal(data, &v)
+	return v.ShuttingDown
+}
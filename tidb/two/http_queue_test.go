@@ -0,0 +1,315 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-26 12:35:08
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 11:20:05
+ * @FilePath: \tidb\two\http_queue_test.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func newHTTPQueue(t *testing.T, maxCap int) (*TiQueue, *HTTPClient) {
+	q := NewTiQueue(maxCap)
+	srv := httptest.NewServer(http.StripPrefix("/queue", NewHTTPServer(q, JSONCodec[Task]{})))
+	t.Cleanup(srv.Close)
+	c := NewHTTPClient(srv.URL+"/queue/", JSONCodec[Task]{})
+	c.SetPollWait(50 * time.Millisecond)
+	return q, c
+}
+
+//TestHTTPQueue 客户端和本地的 TiQueue 语义一致
+func TestHTTPQueue(t *testing.T) {
+
+	q, c := newHTTPQueue(t, 2)
+
+	if err := c.Add(Task{"one", 1}); err != nil {
+		t.Fatal(err)
+	}
+	err := c.Add(Task{"one", 1})
+	var qe *QueueError
+	if !errors.Is(err, ErrItemExist) || !errors.As(err, &qe) || qe.Op != "add" || qe.ID != "one" || qe.Status != Ready {
+		t.Errorf("err %v", err)
+	}
+	c.Add(Task{"two", 2})
+	if err := c.Add(Task{"three", 3}); !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+	if n := c.Len(); n != 2 {
+		t.Errorf("len %v not equal 2", n)
+	}
+	if err := c.Done(Task{"two", 2}); !errors.Is(err, ErrItemNotGet) {
+		t.Errorf("err %v not %v", err, ErrItemNotGet)
+	}
+
+	item, shutdown, err := c.Get(false)
+	if err != nil || shutdown || item != (Task{"one", 1}) {
+		t.Fatalf("item %v shutdown %v err %v", item, shutdown, err)
+	}
+	if status := q.GetItemStatus(item); status != InProcess {
+		t.Errorf("status %04b", status)
+	}
+	//处理中的item可以再加入一次
+	if err := c.Add(Task{"one", 1}); err != nil {
+		t.Error(err)
+	}
+	if err := c.Done(item); err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(item); status != Ready {
+		t.Errorf("status %04b", status)
+	}
+
+	c.Get(false)
+	c.Get(false)
+	if _, _, err := c.Get(false); !errors.Is(err, ErrEmpty) {
+		t.Errorf("err %v not %v", err, ErrEmpty)
+	}
+}
+
+//TestHTTPQueueLongPoll 阻塞的 Get 长轮询等到新加入的item，队列关闭后返回 shutdown
+func TestHTTPQueueLongPoll(t *testing.T) {
+
+	_, c := newHTTPQueue(t, 0)
+
+	got := make(chan Itemer, 1)
+	go func() {
+		item, _, err := c.Get(true)
+		if err != nil {
+			t.Error(err)
+		}
+		got <- item
+	}()
+
+	//超过一次长轮询的时间再加入
+	time.Sleep(120 * time.Millisecond)
+	c.Add(Task{"one", 1})
+	select {
+	case item := <-got:
+		if item != (Task{"one", 1}) {
+			t.Errorf("item %v", item)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("get not return")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	if _, _, err := c.GetContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err %v not %v", err, context.DeadlineExceeded)
+	}
+
+	if c.ShuttingDown() {
+		t.Error("shutting down")
+	}
+	c.ShutDown()
+	if !c.ShuttingDown() {
+		t.Error("not shutting down")
+	}
+	if err := c.Add(Task{"two", 2}); !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+
+	//还有处理中的item，等它完成后返回 shutdown
+	done := make(chan bool, 1)
+	go func() {
+		_, shutdown, _ := c.Get(true)
+		done <- shutdown
+	}()
+	time.Sleep(20 * time.Millisecond)
+	c.Done(Task{"one", 1})
+	if shutdown := <-done; !shutdown {
+		t.Error("not shutdown")
+	}
+}
+
+//TestHTTPQueueConsumers 多个客户端同时消费，每个item只被处理一次
+func TestHTTPQueueConsumers(t *testing.T) {
+
+	q, c := newHTTPQueue(t, 0)
+	for i := 0; i < 50; i++ {
+		c.Add(Task{strconv.Itoa(i), i})
+	}
+
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				item, _, err := c.Get(false)
+				if err != nil {
+					return
+				}
+				mu.Lock()
+				seen[item.GetID()]++
+				mu.Unlock()
+				c.Done(item)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if len(seen) != 50 {
+		t.Errorf("seen %v not equal 50", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("%v handled %v times", id, n)
+		}
+	}
+	if len(q.ItemStatus) != 0 {
+		t.Errorf("status %v", q.ItemStatus)
+	}
+}
+
+//TestHTTPQueueLease 租约到期前可以续约和完成，客户端没有 done 时租约到期后item重新变为 Ready
+func TestHTTPQueueLease(t *testing.T) {
+
+	q, c := newHTTPQueue(t, 0)
+	c.Add(Task{"one", 1})
+	c.Add(Task{"two", 2})
+
+	l, _, err := c.GetWithLease(false, 50*time.Millisecond)
+	if err != nil || l.Item != (Task{"one", 1}) || l.Expiry.IsZero() {
+		t.Fatalf("lease %+v err %v", l, err)
+	}
+	expiry := l.Expiry
+	if err := c.ExtendLease(l, time.Second); err != nil || !l.Expiry.After(expiry) {
+		t.Errorf("expiry %v err %v", l.Expiry, err)
+	}
+	time.Sleep(80 * time.Millisecond)
+	if status := q.GetItemStatus(l.Item); status != InProcess {
+		t.Errorf("status %04b", status)
+	}
+	if err := c.DoneLease(l); err != nil {
+		t.Error(err)
+	}
+	if err := c.DoneLease(l); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("err %v not %v", err, ErrLeaseExpired)
+	}
+
+	//模拟客户端崩溃，租约到期后重新变为 Ready
+	l, _, _ = c.GetWithLease(false, 30*time.Millisecond)
+	time.Sleep(80 * time.Millisecond)
+	if status := q.GetItemStatus(l.Item); status != Ready {
+		t.Errorf("status %04b", status)
+	}
+	if err := c.ExtendLease(l, time.Second); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("err %v not %v", err, ErrLeaseExpired)
+	}
+
+	//重新取到的是新的租约，旧的租约不能完成它
+	l2, _, _ := c.GetWithLease(false, time.Second)
+	if err := c.DoneLease(l); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("err %v not %v", err, ErrLeaseExpired)
+	}
+	if err := c.DoneLease(l2); err != nil {
+		t.Error(err)
+	}
+}
+
+//TestHTTPQueueLeaseToken 同一个item的两次投递有不同的租约，标识不对时返回 409
+func TestHTTPQueueLeaseToken(t *testing.T) {
+
+	q := NewTiQueue(0)
+	srv := httptest.NewServer(NewHTTPServer(q, JSONCodec[Task]{}))
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL, JSONCodec[Task]{})
+
+	c.Add(Task{"one", 1})
+	l1, _, _ := c.GetWithLease(false, time.Minute)
+	c.Add(Task{"one", 1})
+	l2, _, _ := c.GetWithLease(false, time.Minute)
+	if l1.token == "" || l1.token == l2.token {
+		t.Fatalf("token %q %q", l1.token, l2.token)
+	}
+
+	resp, err := http.Post(srv.URL+"/done?id=one&lease=guess", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusConflict {
+		t.Errorf("code %v", resp.StatusCode)
+	}
+
+	//完成的是第二次投递，第一次投递仍然在处理中
+	if err := c.DoneLease(l2); err != nil {
+		t.Error(err)
+	}
+	if err := c.DoneLease(l2); !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("err %v not %v", err, ErrLeaseExpired)
+	}
+	if err := c.ExtendLease(l1, time.Minute); err != nil {
+		t.Error(err)
+	}
+	if err := c.DoneLease(l1); err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(Task{ID: "one"}); status != NotExist {
+		t.Errorf("status %04b", status)
+	}
+}
+
+//TestHTTPQueueMaxBody 超过最大长度的 body 被拒绝
+func TestHTTPQueueMaxBody(t *testing.T) {
+
+	q := NewTiQueue(0)
+	s := NewHTTPServer(q, JSONCodec[Task]{})
+	s.SetMaxBodyBytes(64)
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	c := NewHTTPClient(srv.URL, JSONCodec[Task]{})
+
+	if err := c.Add(Task{"one", 1}); err != nil {
+		t.Error(err)
+	}
+	if err := c.Add(Task{strings.Repeat("x", 100), 2}); err == nil {
+		t.Error("no error")
+	}
+	resp, err := http.Post(srv.URL+"/add", "application/json", strings.NewReader(strings.Repeat("x", 100)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("code %v", resp.StatusCode)
+	}
+	if q.Len() != 1 {
+		t.Errorf("len %v not equal 1", q.Len())
+	}
+}
+
+//TestHTTPQueueRelease 没有交给客户端的item重新加入队列，不算投递次数
+func TestHTTPQueueRelease(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.SetMaxDeliveries(1)
+	q.Add(Task{"one", 1})
+
+	l, _, _ := q.GetWithLease(false, time.Minute)
+	q.releaseLease(l)
+	if status := q.GetItemStatus(l.Item); status != Ready || q.NumDeliveries(l.Item) != 0 {
+		t.Errorf("status %04b deliveries %v", status, q.NumDeliveries(l.Item))
+	}
+
+	item, _, _ := q.Get(false)
+	if dead, _ := q.Nack(item, nil); !dead {
+		t.Error("not dead letter")
+	}
+}
@@ -0,0 +1,145 @@
+/*
+ * @Description:invariants
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-23 16:30:05
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-23 17:42:18
+ * @FilePath: \tidb\two\invariants.go
+ */
+package two
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//状态不一致的错误，通过 StatusError.Err 或者 errors.Is 判断
+var (
+	ErrStatusNotFound = errors.New("item status not found")                    //队列中或者处理中的item没有状态
+	ErrInvalidStatus  = errors.New("invalid item status")                      //状态不是合法的2bit编码
+	ErrStatusMismatch = errors.New("item status does not match queue content") //状态和队列中、处理中的item个数对不上
+)
+
+//StatusError item 的状态和队列的内容不一致
+type StatusError struct {
+	ID     string
+	Status ItemStatus
+	Err    error  //ErrStatusNotFound、ErrInvalidStatus 或者 ErrStatusMismatch
+	Detail string //具体哪里不一致
+}
+
+func (e *StatusError) Error() string {
+	msg := fmt.Sprintf("item %v status %04b: %v", e.ID, e.Status, e.Err)
+	if e.Detail != "" {
+		msg += ", " + e.Detail
+	}
+	return msg
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
+
+//copies 合法的状态中 Ready 和处理中的item各有几个，不合法时 ok 为 false
+func copies(status ItemStatus) (ready, inprocess int, ok bool) {
+	switch status {
+	case Ready:
+		return 1, 0, true
+	case InProcess:
+		return 0, 1, true
+	case (Ready << 2) | InProcess:
+		return 1, 1, true
+	case (InProcess << 2) | InProcess:
+		return 0, 2, true
+	}
+	return 0, 0, false
+}
+
+//checkReady 从队列中取出的item状态必须是有一个 Ready，调用者需要持有锁
+func (q *TiQueue) checkReady(item Itemer) *StatusError {
+
+	status, ok := q.ItemStatus[item.GetID()]
+	if !ok {
+		return &StatusError{ID: item.GetID(), Err: ErrStatusNotFound, Detail: "queued"}
+	}
+	if status != Ready && status != (Ready<<2)|InProcess {
+		return &StatusError{ID: item.GetID(), Status: status, Err: ErrInvalidStatus, Detail: "queued"}
+	}
+	return nil
+}
+
+/*
+CheckInvariants 检查状态和队列的内容是否一致，返回所有不一致的地方，一致时返回nil。
+每个item的状态必须是合法的2bit编码，并且队列中 Ready 的个数、处理中的个数和状态对得上。
+需要遍历所有item，只用于调试和测试
+*/
+func (q *TiQueue) CheckInvariants() []error {
+
+	q.Lock()
+	defer q.Unlock()
+
+	var errs []error
+
+	queued := make(map[string]int, 0)
+	q.items.each(func(e *entry) bool {
+		queued[e.item.GetID()]++
+		if q.ready[e.item.GetID()] != e {
+			errs = append(errs, &StatusError{ID: e.item.GetID(), Status: q.ItemStatus[e.item.GetID()],
+				Err: ErrStatusMismatch, Detail: "queued item not indexed"})
+		}
+		return true
+	})
+	if len(q.ready) != q.items.len() {
+		errs = append(errs, fmt.Errorf("%w: %v indexed, %v queued", ErrStatusMismatch, len(q.ready), q.items.len()))
+	}
+
+	ids := make(map[string]bool, 0)
+	for id := range q.ItemStatus {
+		ids[id] = true
+	}
+	for id := range queued {
+		ids[id] = true
+	}
+	for id := range q.processing {
+		ids[id] = true
+	}
+
+	for id := range ids {
+		status, ok := q.ItemStatus[id]
+		if !ok {
+			errs = append(errs, &StatusError{ID: id, Err: ErrStatusNotFound,
+				Detail: fmt.Sprintf("%v queued, %v in process", queued[id], len(q.processing[id]))})
+			continue
+		}
+
+		ready, inprocess, ok := copies(status)
+		if !ok {
+			errs = append(errs, &StatusError{ID: id, Status: status, Err: ErrInvalidStatus})
+			continue
+		}
+		if queued[id] != ready {
+			errs = append(errs, &StatusError{ID: id, Status: status, Err: ErrStatusMismatch,
+				Detail: fmt.Sprintf("%v queued, want %v", queued[id], ready)})
+		}
+		if len(q.processing[id]) != inprocess {
+			errs = append(errs, &StatusError{ID: id, Status: status, Err: ErrStatusMismatch,
+				Detail: fmt.Sprintf("%v in process, want %v", len(q.processing[id]), inprocess)})
+		}
+	}
+
+	//按ID排序，方便对比
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errorID(errs[i]) < errorID(errs[j])
+	})
+	return errs
+}
+
+func errorID(err error) string {
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se.ID
+	}
+	return ""
+}
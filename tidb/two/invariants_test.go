@@ -0,0 +1,115 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-23 17:45:10
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-23 18:20:44
+ * @FilePath: \tidb\two\invariants_test.go
+ */
+package two
+
+import (
+	"errors"
+	"testing"
+)
+
+//TestGetInconsistentStatus 状态不一致时 Get 返回错误而不是panic，后面的item不受影响
+func TestGetInconsistentStatus(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+	q.Add(StringItem("three"))
+
+	q.setItemStatus(StringItem("one"), InProcess)
+	q.Lock()
+	delete(q.ItemStatus, "two")
+	q.Unlock()
+
+	_, _, err := q.Get(false)
+	var se *StatusError
+	if !errors.As(err, &se) || se.ID != "one" || !errors.Is(err, ErrInvalidStatus) {
+		t.Errorf("err %v", err)
+	}
+	_, _, err = q.Get(false)
+	if !errors.Is(err, ErrStatusNotFound) {
+		t.Errorf("err %v not %v", err, ErrStatusNotFound)
+	}
+
+	item, _, err := q.Get(false)
+	if err != nil || item.GetID() != "three" {
+		t.Errorf("item %v err %v", item, err)
+	}
+}
+
+//TestCheckInvariants 正常使用时状态总是一致的
+func TestCheckInvariants(t *testing.T) {
+
+	for name, q := range map[string]*TiQueue{
+		"fifo":     NewTiQueue(0),
+		"priority": NewPriorityTiQueue(0, 0),
+		"ordered":  NewOrderedTiQueue(0),
+	} {
+		for i := 0; i < 10; i++ {
+			q.Add(IntItem(i))
+		}
+		a, _, _ := q.Get(false)
+		b, _, _ := q.Get(false)
+		q.Add(a)
+		q.Remove("5")
+		q.Nack(b, nil)
+		q.AddOrMerge(IntItem(6))
+		if errs := q.CheckInvariants(); errs != nil {
+			t.Errorf("%v %v", name, errs)
+		}
+
+		q.Done(a)
+		for _, item := range drain(q) {
+			q.Done(item)
+		}
+		if errs := q.CheckInvariants(); errs != nil {
+			t.Errorf("%v %v", name, errs)
+		}
+	}
+}
+
+//TestCheckInvariantsViolation 报告所有不一致的地方
+func TestCheckInvariantsViolation(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.Add(StringItem("a"))
+	q.Add(StringItem("b"))
+	q.Add(StringItem("c"))
+	q.Get(false)
+
+	q.setItemStatus(StringItem("a"), (InProcess<<2)|InProcess) //处理中的只有一个
+	q.setItemStatus(StringItem("b"), InProcess)                //队列中的被标记为处理中
+	q.setItemStatus(StringItem("c"), 0x5)                      //不合法
+	q.setItemStatus(StringItem("d"), Ready)                    //队列中没有
+	q.Lock()
+	q.processing["e"] = []*inflight{{item: StringItem("e")}} //没有状态
+	q.Unlock()
+
+	errs := q.CheckInvariants()
+	want := []struct {
+		id  string
+		err error
+	}{
+		{"a", ErrStatusMismatch},
+		{"b", ErrStatusMismatch}, //队列中多了一个，处理中少了一个
+		{"b", ErrStatusMismatch},
+		{"c", ErrInvalidStatus},
+		{"d", ErrStatusMismatch},
+		{"e", ErrStatusNotFound},
+	}
+	if len(errs) != len(want) {
+		t.Fatalf("errs %v", errs)
+	}
+	for i, w := range want {
+		var se *StatusError
+		if !errors.As(errs[i], &se) || se.ID != w.id || !errors.Is(errs[i], w.err) {
+			t.Errorf("%v err %v not %v %v", i, errs[i], w.id, w.err)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+/*
+ * @Description:lease
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-20 10:40:26
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 11:20:05
+ * @FilePath: \tidb\two\lease.go
+ */
+package two
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+)
+
+var ErrLeaseExpired = errors.New("lease expired") //租约已经过期或者item已经处理完成
+
+/*
+Lease GetWithLease 返回的租约，类似 SQS 的可见性超时。
+消费者在 Expiry 之前需要 DoneLease 或者 ExtendLease 续约，否则认为消费者已经崩溃，
+item 会自动重新变为 Ready 被其他消费者处理。重新变为 Ready 时仍然遵守2个bit的状态编码：
+
+1. 只有一个处理中的item  0010 -> 0001，item 重新加入队尾
+2. 处理中的item又被加入了一次 0110 -> 0001，队列中已经有了，不用再加入
+3. 相同的item两个都在处理中 1010 -> 0110，item 重新加入队尾
+*/
+type Lease struct {
+	Item   Itemer
+	Expiry time.Time //租约到期的时间，ExtendLease 后会更新
+	p      *inflight
+	token  string //HTTPClient 的租约标识，本地的租约为空
+}
+
+//GetWithLease 获取item并设置租约，block 标记是否阻塞读，ttl 小于等于0时不设置租约
+func (q *TiQueue) GetWithLease(block bool, ttl time.Duration) (lease *Lease, shutdown bool, err error) {
+	return q.getLease(context.Background(), block, ttl)
+}
+
+func (q *TiQueue) getLease(ctx context.Context, block bool, ttl time.Duration) (lease *Lease, shutdown bool, err error) {
+
+	p, shutdown, err := q.get(ctx, block, ttl)
+	if p == nil {
+		return
+	}
+
+	q.Lock()
+	lease = &Lease{Item: p.item, Expiry: p.expiry, p: p}
+	q.Unlock()
+	return
+}
+
+//issueToken 给租约生成随机的标识，HTTPServer 通过它找到这次投递
+func (q *TiQueue) issueToken(l *Lease) error {
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	q.Lock()
+	l.p.token = hex.EncodeToString(b)
+	l.token = l.p.token
+	q.Unlock()
+	return nil
+}
+
+//findLease 根据唯一标识和租约标识找到处理中的item的租约，已经处理完成、重新变为 Ready 或者标识不对时返回nil
+func (q *TiQueue) findLease(id string, token string) *Lease {
+	q.Lock()
+	defer q.Unlock()
+
+	for _, p := range q.processing[id] {
+		if token != "" && p.token == token {
+			return &Lease{Item: p.item, Expiry: p.expiry, p: p, token: token}
+		}
+	}
+	return nil
+}
+
+//nackLease 租约对应的item处理失败，投递次数用完时放入死信，否则重新变为 Ready，已经处理完成时忽略
+func (q *TiQueue) nackLease(l *Lease, reason error) {
+	q.Lock()
+	defer q.Unlock()
+	if q.holding(l.p) && !l.p.retrying {
+		q.nackInflight(l.p, reason)
+	}
+}
+
+//releaseLease 租约对应的item没有交给消费者，重新变为 Ready，不算投递次数
+func (q *TiQueue) releaseLease(l *Lease) {
+	q.Lock()
+	defer q.Unlock()
+	q.releaseInflight(l.p)
+}
+
+//ExtendLease 续约，租约在 ttl 之后到期。租约已经过期或者item已经处理完成时返回错误
+func (q *TiQueue) ExtendLease(l *Lease, ttl time.Duration) (err error) {
+	q.Lock()
+	defer q.Unlock()
+	defer func() { err = q.opError("lease", l.Item.GetID(), err) }()
+
+	if !q.holding(l.p) || l.p.retrying {
+		return ErrLeaseExpired
+	}
+
+	q.lease(l.p, ttl)
+	l.Expiry = l.p.expiry
+	return nil
+}
+
+//DoneLease 租约对应的item处理完成。租约已经过期时返回错误，这时item可能已经被其他消费者处理了
+func (q *TiQueue) DoneLease(l *Lease) (err error) {
+	q.Lock()
+	defer q.Unlock()
+	defer func() { err = q.opError("lease", l.Item.GetID(), err) }()
+
+	if !q.holding(l.p) || l.p.retrying {
+		return ErrLeaseExpired
+	}
+	q.forget(l.Item)
+	return q.finish(l.Item, l.p)
+}
+
+//NumLeaseExpirations item 的租约过期了多少次，item 处理完成后清零
+func (q *TiQueue) NumLeaseExpirations(item Itemer) int {
+	q.Lock()
+	defer q.Unlock()
+	return q.expirations[item.GetID()]
+}
+
+//lease 设置或者更新租约，调用者需要持有锁
+func (q *TiQueue) lease(p *inflight, ttl time.Duration) {
+
+	p.expiry = time.Now().Add(ttl)
+	if p.timer != nil {
+		p.timer.Reset(ttl)
+		return
+	}
+	p.timer = time.AfterFunc(ttl, func() {
+		q.expireLease(p)
+	})
+}
+
+//holding 处理中的记录是否还有效，调用者需要持有锁
+func (q *TiQueue) holding(p *inflight) bool {
+	if p == nil {
+		return false
+	}
+	for _, v := range q.processing[p.item.GetID()] {
+		if v == p {
+			return true
+		}
+	}
+	return false
+}
+
+//expireLease 租约到期，item 重新变为 Ready，投递次数用完时放入死信
+func (q *TiQueue) expireLease(p *inflight) {
+	q.Lock()
+	defer q.Unlock()
+
+	//已经处理完成了，或者已经在等待重试
+	if !q.holding(p) || p.retrying {
+		return
+	}
+	//到期的同时被续约了，timer 已经重新设置
+	if time.Now().Before(p.expiry) {
+		return
+	}
+
+	q.expirations[p.item.GetID()]++
+	if q.exhausted(p.item) {
+		q.deadLetter(p, ErrLeaseExpired)
+		return
+	}
+	q.requeueInflight(p)
+}
+
+//requeueInflight 处理中的item重新变为 Ready，不受容量限制，队列关闭后也可以，调用者需要持有锁
+func (q *TiQueue) requeueInflight(p *inflight) {
+
+	id := p.item.GetID()
+	q.removeInflight(id, p)
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+
+	//日志中记为完成后重新加入，日志写失败也要恢复item，避免item永远处于处理中
+	q.journal(walDone, p.item)
+
+	//去掉一个处理中的状态
+	old := q.ItemStatus[id]
+	rest := old >> 2
+	if rest == Ready {
+		q.ItemStatus[id] = Ready
+		q.emit(EventRequeue, p.item, old, nil)
+		return
+	}
+
+	q.journal(walAdd, p.item)
+	if rest == NotExist {
+		q.ItemStatus[id] = Ready
+	} else {
+		q.ItemStatus[id] = (Ready << 2) | rest
+	}
+	e := &entry{item: p.item, addedAt: time.Now(), prio: p.prio}
+	if ks, ok := q.items.(*keyedStore); ok {
+		//有序队列中放回分区的队头，保证在分区后面的item之前重试
+		ks.pushFront(e)
+	} else {
+		q.items.push(e)
+	}
+	q.ready[id] = e
+	q.emit(EventRequeue, p.item, old, nil)
+	q.metrics.SetDepth(q.items.len())
+	q.maybeCompact()
+
+	//唤醒等待的 Get
+	if q.notEmpty != nil {
+		close(q.notEmpty)
+		q.notEmpty = nil
+	}
+}
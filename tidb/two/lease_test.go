@@ -0,0 +1,151 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-20 11:55:02
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:21:01
+ * @FilePath: \tidb\two\lease_test.go
+ */
+package two
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//TestLeaseExpire 租约到期后item重新变为 Ready
+func TestLeaseExpire(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+
+	lease, _, err := q.GetWithLease(false, 20*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lease.Item.GetID() != "one" || lease.Expiry.IsZero() {
+		t.Errorf("lease %v", lease)
+	}
+	if status := q.GetItemStatus(lease.Item); status != InProcess {
+		t.Errorf("status %v not equal InProcess", status)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if status := q.GetItemStatus(lease.Item); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+	if n := q.NumLeaseExpirations(lease.Item); n != 1 {
+		t.Errorf("expirations %v not equal 1", n)
+	}
+
+	//重新加入队尾
+	item, _, _ := q.Get(false)
+	if item.GetID() != "two" {
+		t.Errorf("%v not equal two", item.GetID())
+	}
+	item, _, _ = q.Get(false)
+	if item.GetID() != "one" {
+		t.Errorf("%v not equal one", item.GetID())
+	}
+
+	//过期的租约不能再完成和续约
+	err = q.DoneLease(lease)
+	if !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("err %v not %v", err, ErrLeaseExpired)
+	}
+	err = q.ExtendLease(lease, time.Second)
+	if !errors.Is(err, ErrLeaseExpired) {
+		t.Errorf("err %v not %v", err, ErrLeaseExpired)
+	}
+
+	//处理完成后计数清零
+	q.Done(item)
+	if n := q.NumLeaseExpirations(item); n != 0 {
+		t.Errorf("expirations %v not equal 0", n)
+	}
+
+}
+
+//TestExtendLease 续约后不会过期
+func TestExtendLease(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.Add(StringItem("one"))
+
+	lease, _, _ := q.GetWithLease(true, 30*time.Millisecond)
+	expiry := lease.Expiry
+	for i := 0; i < 4; i++ {
+		time.Sleep(15 * time.Millisecond)
+		err := q.ExtendLease(lease, 30*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !lease.Expiry.After(expiry) {
+		t.Errorf("expiry %v not after %v", lease.Expiry, expiry)
+	}
+	if status := q.GetItemStatus(lease.Item); status != InProcess {
+		t.Errorf("status %v not equal InProcess", status)
+	}
+
+	err := q.DoneLease(lease)
+	if err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(lease.Item); status != NotExist {
+		t.Errorf("status %v not equal NotExist", status)
+	}
+
+	//完成后定时器已经停止
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+
+}
+
+//TestLeaseExpireDuplicate 过期时相同的item已经在队列中或者也在处理中
+func TestLeaseExpireDuplicate(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.Add(StringItem("one"))
+	old, _, _ := q.GetWithLease(false, 20*time.Millisecond)
+	q.Add(StringItem("one"))
+
+	//0110 -> 0001 队列中只有一个
+	time.Sleep(50 * time.Millisecond)
+	if status := q.GetItemStatus(old.Item); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+	if q.Len() != 1 {
+		t.Errorf("len %v not equal 1", q.Len())
+	}
+
+	//1010 -> 0110 较早的过期
+	old, _, _ = q.GetWithLease(false, 20*time.Millisecond)
+	q.Add(StringItem("one"))
+	newer, _, _ := q.GetWithLease(false, time.Second)
+	if status := q.GetItemStatus(old.Item); status != (InProcess<<2)|InProcess {
+		t.Errorf("status %v not equal (InProcess<<2)|InProcess", status)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if status := q.GetItemStatus(old.Item); status != (Ready<<2)|InProcess {
+		t.Errorf("status %v not equal (Ready<<2)|InProcess", status)
+	}
+	if n := q.NumLeaseExpirations(old.Item); n != 2 {
+		t.Errorf("expirations %v not equal 2", n)
+	}
+
+	//较新的仍然有效
+	err := q.DoneLease(newer)
+	if err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(old.Item); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+
+}
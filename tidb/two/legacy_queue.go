@@ -0,0 +1,77 @@
+/*
+ * @Description:legacy queue adapter
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 16:15:44
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-18 16:47:30
+ * @FilePath: \tidb\two\legacy_queue.go
+ */
+package two
+
+import "fmt"
+
+/*
+LegacyQueue 题目最初给出的队列接口形式，item 为 interface{}，不返回错误
+新代码应该直接使用 Queue，这里只是为了兼容按照最初接口编写的代码
+*/
+type LegacyQueue interface {
+	Add(item interface{})                   // 添加 item 到队列。
+	Get() (item interface{}, shutdown bool) //获取 item。shutdown 如果为 true, 调用者应关闭请求。处理完 item 后应调用 Done 方法。
+	Len() int                               //返回队列长度，不包含正在处理的 item。
+	Done(item interface{})                  //表示 item 被处理完成。
+	ShutDown()                              //关闭队列，不再接收 Add 请求，待队列 item 被处理完后关闭。
+	ShuttingDown() bool                     //队列是否正在关闭。
+}
+
+var _ LegacyQueue = (*legacyQueue)(nil)
+
+//legacyQueue 把 Queue 适配为 LegacyQueue
+type legacyQueue struct {
+	q Queue
+}
+
+//NewLegacyQueue 把 Queue 适配为 LegacyQueue
+//Add 的 item 必须实现 Itemer，否则无法去重，会直接panic；
+//Add 和 Done 的错误(队列已满、已关闭、item已存在等)会被忽略，需要感知这些错误的调用者应直接使用 Queue
+func NewLegacyQueue(q Queue) LegacyQueue {
+	return &legacyQueue{q: q}
+}
+
+func (l *legacyQueue) Add(item interface{}) {
+	l.q.Add(mustItemer(item))
+}
+
+//Get 阻塞读，直到获取到 item 或者队列关闭
+func (l *legacyQueue) Get() (item interface{}, shutdown bool) {
+
+	it, shutdown, err := l.q.Get(true)
+	if err != nil || shutdown {
+		return nil, true
+	}
+	return it, false
+}
+
+func (l *legacyQueue) Len() int {
+	return l.q.Len()
+}
+
+func (l *legacyQueue) Done(item interface{}) {
+	l.q.Done(mustItemer(item))
+}
+
+func (l *legacyQueue) ShutDown() {
+	l.q.ShutDown()
+}
+
+func (l *legacyQueue) ShuttingDown() bool {
+	return l.q.ShuttingDown()
+}
+
+func mustItemer(item interface{}) Itemer {
+	it, ok := item.(Itemer)
+	if !ok {
+		panic(fmt.Sprintf("item %v does not implement Itemer", item))
+	}
+	return it
+}
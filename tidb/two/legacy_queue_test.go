@@ -0,0 +1,73 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 16:50:12
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-18 17:05:48
+ * @FilePath: \tidb\two\legacy_queue_test.go
+ */
+package two
+
+import (
+	"sync"
+	"testing"
+)
+
+//TestLegacyQueue 按照最初的接口使用队列
+func TestLegacyQueue(t *testing.T) {
+
+	var q LegacyQueue = NewLegacyQueue(NewTiQueue(10))
+	for v := 0; v < 10; v++ {
+		q.Add(IntItem(v))
+	}
+	//重复添加会被忽略
+	q.Add(IntItem(0))
+	if q.Len() != 10 {
+		t.Errorf("len %v not equal %v", q.Len(), 10)
+	}
+
+	res := make([]int, 0)
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		for {
+			item, shutdown := q.Get()
+			if shutdown {
+				break
+			}
+			res = append(res, int(item.(IntItem)))
+			q.Done(item)
+		}
+		wg.Done()
+	}()
+
+	q.ShutDown()
+	wg.Wait()
+
+	if len(res) != 10 {
+		t.Errorf("consume %v not equal %v", len(res), 10)
+	}
+	for i := range res {
+		if res[i] != i {
+			t.Errorf("%v not equal %v", res[i], i)
+		}
+	}
+	if q.ShuttingDown() {
+		t.Errorf("shutingdown %v not %v", true, false)
+	}
+
+}
+
+//TestLegacyQueueNotItemer 没有实现Itemer的item无法去重
+func TestLegacyQueueNotItemer(t *testing.T) {
+
+	defer func() {
+		if r := recover(); r == nil {
+			t.Errorf("add %v should panic", 1)
+		}
+	}()
+
+	q := NewLegacyQueue(NewTiQueue(10))
+	q.Add(1)
+}
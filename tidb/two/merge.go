@@ -0,0 +1,74 @@
+/*
+ * @Description:merge
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-22 15:10:27
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-25 11:49:22
+ * @FilePath: \tidb\two\merge.go
+ */
+package two
+
+import "errors"
+
+var ErrMergeID = errors.New("merged item id changed") //合并后的item ID 必须和原来的一样
+
+//Merger item 可以实现这个接口，AddOrMerge 时把新加入的 newer 合并到队列中等待的item上，返回合并后的item
+type Merger interface {
+	Merge(newer Itemer) Itemer
+}
+
+//MergeFunc 队列级别的合并方式，queued 为队列中等待的item，newer 为新加入的item
+type MergeFunc func(queued, newer Itemer) Itemer
+
+//SetMergeFunc 设置 AddOrMerge 的合并方式，优先于item自己的 Merger
+func (q *TiQueue) SetMergeFunc(fn MergeFunc) {
+	q.Lock()
+	q.merge = fn
+	q.Unlock()
+}
+
+/*
+AddOrMerge 添加item，队列中已经有等待处理的相同item时合并而不是返回 item 已经存在的错误，merged 标记是否发生了合并。
+合并方式依次为 SetMergeFunc 设置的函数、item 实现的 Merger、直接用新的item替换，合并后item在队列中的位置和优先级都不变。
+只有处理中的item时和 Add 一样再加入一次
+*/
+func (q *TiQueue) AddOrMerge(item Itemer) (merged bool, err error) {
+
+	q.Lock()
+	defer q.Unlock()
+	defer func() { err = q.opError("add", item.GetID(), err) }()
+
+	e, ok := q.ready[item.GetID()]
+	if !ok || q.closed() {
+		err = q.add(item, 0)
+		if err != nil {
+			q.rejected(item, err)
+		}
+		return false, err
+	}
+
+	var m Itemer
+	switch {
+	case q.merge != nil:
+		m = q.merge(e.item, item)
+	default:
+		if merger, ok := e.item.(Merger); ok {
+			m = merger.Merge(item)
+		} else {
+			m = item
+		}
+	}
+	if m == nil || m.GetID() != item.GetID() {
+		return false, ErrMergeID
+	}
+
+	//日志中记为重新加入，回放时会替换掉原来的item
+	if err = q.journal(walAdd, m); err != nil {
+		return false, err
+	}
+	e.item = m
+	q.emit(EventCollapse, m, q.ItemStatus[m.GetID()], nil)
+	q.maybeCompact()
+	return true, nil
+}
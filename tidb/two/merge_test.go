@@ -0,0 +1,121 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-22 16:25:03
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:23:03
+ * @FilePath: \tidb\two\merge_test.go
+ */
+package two
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+//SumTask 合并时把 Data 累加
+type SumTask Task
+
+func (t SumTask) GetID() string {
+	return t.ID
+}
+
+func (t SumTask) Merge(newer Itemer) Itemer {
+	t.Data += newer.(SumTask).Data
+	return t
+}
+
+//TestAddOrMergeReplace 没有合并方式时用新的item替换，位置不变
+func TestAddOrMergeReplace(t *testing.T) {
+
+	q := NewTiQueue(2)
+	q.Add(Task{"one", 1})
+	q.Add(Task{"two", 2})
+
+	//队列满了也可以合并
+	merged, err := q.AddOrMerge(Task{"one", 10})
+	if err != nil || !merged {
+		t.Fatalf("merged %v err %v", merged, err)
+	}
+	merged, err = q.AddOrMerge(Task{"three", 3})
+	if !errors.Is(err, ErrExceedCap) || merged {
+		t.Errorf("merged %v err %v", merged, err)
+	}
+
+	items := drain(q)
+	if len(items) != 2 || items[0] != (Task{"one", 10}) || items[1] != (Task{"two", 2}) {
+		t.Errorf("items %v", items)
+	}
+}
+
+//TestAddOrMergeMerger 使用item实现的 Merger，队列的合并函数优先
+func TestAddOrMergeMerger(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.Add(SumTask{"one", 1})
+	q.AddOrMerge(SumTask{"one", 2})
+	q.AddOrMerge(SumTask{"one", 3})
+
+	item, _, _ := q.Get(false)
+	if item != (SumTask{"one", 6}) {
+		t.Errorf("%v not equal 6", item)
+	}
+
+	//只有处理中的item时再加入一次
+	merged, err := q.AddOrMerge(SumTask{"one", 1})
+	if err != nil || merged {
+		t.Errorf("merged %v err %v", merged, err)
+	}
+	if status := q.GetItemStatus(item); status != (Ready<<2)|InProcess {
+		t.Errorf("status %b", status)
+	}
+
+	//处理中的同时队列中也有一个，合并到队列中的那个
+	q.SetMergeFunc(func(queued, newer Itemer) Itemer {
+		return SumTask{queued.GetID(), queued.(SumTask).Data * newer.(SumTask).Data}
+	})
+	merged, _ = q.AddOrMerge(SumTask{"one", 5})
+	if !merged {
+		t.Error("not merged")
+	}
+	q.Done(item)
+	item, _, _ = q.Get(false)
+	if item != (SumTask{"one", 5}) {
+		t.Errorf("%v not equal 5", item)
+	}
+
+	//合并后 ID 变了
+	q.Add(SumTask{"two", 1})
+	q.SetMergeFunc(func(queued, newer Itemer) Itemer {
+		return SumTask{"other", 0}
+	})
+	if _, err := q.AddOrMerge(SumTask{"two", 1}); !errors.Is(err, ErrMergeID) {
+		t.Errorf("err %v not %v", err, ErrMergeID)
+	}
+}
+
+//TestAddOrMergeDurable 合并后的item重启后仍然在原来的位置
+func TestAddOrMergeDurable(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(Task{"one", 1})
+	q.Add(Task{"two", 2})
+	q.AddOrMerge(Task{"one", 11})
+	q.Close()
+
+	q, err = NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	items := drain(q)
+	if len(items) != 2 || items[0] != (Task{"one", 11}) || items[1] != (Task{"two", 2}) {
+		t.Errorf("items %v", items)
+	}
+}
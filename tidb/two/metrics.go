@@ -0,0 +1,129 @@
+/*
+ * @Description:metrics
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 11:10:05
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 11:20:05
+ * @FilePath: \tidb\two\metrics.go
+ */
+package two
+
+import (
+	"errors"
+	"time"
+)
+
+//unfinishedWorkUpdatePeriod 多久统计一次处理中item的耗时
+const unfinishedWorkUpdatePeriod = 500 * time.Millisecond
+
+//Add 被拒绝的原因
+const (
+	RejectExceedCap = "exceed_cap" //队列已满
+	RejectExist     = "exist"      //item 已经存在
+	RejectClosed    = "closed"     //队列已关闭
+)
+
+/*
+MetricsProvider 队列的监控指标，TiQueue 在持有锁的情况下调用，实现需要并发安全并且尽量轻量
+*/
+type MetricsProvider interface {
+	SetDepth(depth int)                          //队列中 Ready 状态的item个数
+	IncAdds()                                    //成功 Add 的次数
+	IncRejected(reason string)                   //Add 被拒绝的次数，reason 见 RejectExceedCap 等
+	ObserveLatency(d time.Duration)              //item 从 Add 到被 Get 等待的时间
+	ObserveWorkDuration(d time.Duration)         //item 从 Get 到 Done 处理的时间
+	SetUnfinishedWorkSeconds(s float64)          //所有处理中的item已经处理了多久，用来发现卡住的消费者
+	SetLongestRunningProcessorSeconds(s float64) //处理最久的那个item已经处理了多久
+}
+
+//inflight 处理中的item
+type inflight struct {
+	item   Itemer
+	start  time.Time   //被 Get 的时间
+	expiry time.Time   //租约到期的时间，没有租约时为零值
+	timer  *time.Timer //租约到期或者等待重试结束后把item重新变为 Ready
+
+	retrying bool   //处理失败了，正在等待重试，不再属于任何消费者
+	prio     int    //取出时的优先级，重新变为 Ready 时保持不变
+	token    string //HTTPServer 给这次投递生成的随机租约标识，用来区分同一个item的不同投递
+}
+
+//noopMetrics 默认不统计
+type noopMetrics struct{}
+
+func (noopMetrics) SetDepth(depth int)                          {}
+func (noopMetrics) IncAdds()                                    {}
+func (noopMetrics) IncRejected(reason string)                   {}
+func (noopMetrics) ObserveLatency(d time.Duration)              {}
+func (noopMetrics) ObserveWorkDuration(d time.Duration)         {}
+func (noopMetrics) SetUnfinishedWorkSeconds(s float64)          {}
+func (noopMetrics) SetLongestRunningProcessorSeconds(s float64) {}
+
+//rejectReason Add 返回的错误对应的拒绝原因，不是拒绝(比如ctx超时)返回空
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, ErrExceedCap):
+		return RejectExceedCap
+	case errors.Is(err, ErrItemExist):
+		return RejectExist
+	case errors.Is(err, ErrClosed):
+		return RejectClosed
+	}
+	return ""
+}
+
+//SetMetricsProvider 设置监控指标，同时开始周期统计处理中item的耗时，直到队列关闭并且item都处理完
+func (q *TiQueue) SetMetricsProvider(p MetricsProvider) {
+	q.Lock()
+	q.metrics = p
+	p.SetDepth(q.items.len())
+	q.Unlock()
+
+	q.metricsOnce.Do(func() {
+		go q.updateUnfinishedWorkLoop()
+	})
+}
+
+func (q *TiQueue) updateUnfinishedWorkLoop() {
+
+	tk := time.NewTicker(unfinishedWorkUpdatePeriod)
+	defer tk.Stop()
+	for range tk.C {
+		q.Lock()
+		q.updateUnfinishedWork()
+		stop := q.closed() && len(q.ItemStatus) == 0
+		q.Unlock()
+
+		if stop {
+			return
+		}
+	}
+}
+
+//updateUnfinishedWork 统计处理中item的耗时，调用者需要持有锁
+func (q *TiQueue) updateUnfinishedWork() {
+
+	now := time.Now()
+	var total, longest time.Duration
+	for _, items := range q.processing {
+		for _, p := range items {
+			d := now.Sub(p.start)
+			total += d
+			if d > longest {
+				longest = d
+			}
+		}
+	}
+
+	q.metrics.SetUnfinishedWorkSeconds(total.Seconds())
+	q.metrics.SetLongestRunningProcessorSeconds(longest.Seconds())
+}
+
+//rejected 记录 Add 被拒绝，调用者需要持有锁
+func (q *TiQueue) rejected(item Itemer, err error) {
+	q.emit(EventReject, item, q.ItemStatus[item.GetID()], err)
+	if reason := rejectReason(err); reason != "" {
+		q.metrics.IncRejected(reason)
+	}
+}
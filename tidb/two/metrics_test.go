@@ -0,0 +1,120 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 14:22:40
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-19 15:03:12
+ * @FilePath: \tidb\two\metrics_test.go
+ */
+package two
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//testMetrics 记录队列调用的指标
+type testMetrics struct {
+	mu          sync.Mutex
+	depth       int
+	adds        int
+	rejected    map[string]int
+	latency     []time.Duration
+	workDur     []time.Duration
+	unfinished  float64
+	longestProc float64
+}
+
+func newTestMetrics() *testMetrics {
+	return &testMetrics{rejected: make(map[string]int, 0)}
+}
+
+func (m *testMetrics) SetDepth(depth int) {
+	m.mu.Lock()
+	m.depth = depth
+	m.mu.Unlock()
+}
+func (m *testMetrics) IncAdds() {
+	m.mu.Lock()
+	m.adds++
+	m.mu.Unlock()
+}
+func (m *testMetrics) IncRejected(reason string) {
+	m.mu.Lock()
+	m.rejected[reason]++
+	m.mu.Unlock()
+}
+func (m *testMetrics) ObserveLatency(d time.Duration) {
+	m.mu.Lock()
+	m.latency = append(m.latency, d)
+	m.mu.Unlock()
+}
+func (m *testMetrics) ObserveWorkDuration(d time.Duration) {
+	m.mu.Lock()
+	m.workDur = append(m.workDur, d)
+	m.mu.Unlock()
+}
+func (m *testMetrics) SetUnfinishedWorkSeconds(s float64) {
+	m.mu.Lock()
+	m.unfinished = s
+	m.mu.Unlock()
+}
+func (m *testMetrics) SetLongestRunningProcessorSeconds(s float64) {
+	m.mu.Lock()
+	m.longestProc = s
+	m.mu.Unlock()
+}
+
+func TestMetrics(t *testing.T) {
+
+	m := newTestMetrics()
+	q := NewTiQueue(2)
+	q.SetMetricsProvider(m)
+
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+	q.Add(StringItem("one"))   //已存在
+	q.Add(StringItem("three")) //队列已满
+
+	if m.adds != 2 || m.depth != 2 {
+		t.Errorf("adds %v depth %v", m.adds, m.depth)
+	}
+	if m.rejected[RejectExist] != 1 || m.rejected[RejectExceedCap] != 1 {
+		t.Errorf("rejected %v", m.rejected)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	item, _, _ := q.Get(false)
+	if m.depth != 1 || len(m.latency) != 1 || m.latency[0] < 10*time.Millisecond {
+		t.Errorf("depth %v latency %v", m.depth, m.latency)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	q.Lock()
+	q.updateUnfinishedWork()
+	q.Unlock()
+	if m.unfinished < 0.01 || m.longestProc < 0.01 {
+		t.Errorf("unfinished %v longest %v", m.unfinished, m.longestProc)
+	}
+
+	q.Done(item)
+	if len(m.workDur) != 1 || m.workDur[0] < 10*time.Millisecond {
+		t.Errorf("work duration %v", m.workDur)
+	}
+
+	q.Lock()
+	q.updateUnfinishedWork()
+	q.Unlock()
+	if m.unfinished != 0 || m.longestProc != 0 {
+		t.Errorf("unfinished %v longest %v", m.unfinished, m.longestProc)
+	}
+
+	q.ShutDown()
+	q.Add(StringItem("four"))
+	if m.rejected[RejectClosed] != 1 {
+		t.Errorf("rejected %v", m.rejected)
+	}
+
+}
@@ -0,0 +1,219 @@
+/*
+ * @Description:ordered queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-22 09:12:48
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-23 09:52:30
+ * @FilePath: \tidb\two\ordered.go
+ */
+package two
+
+import "container/heap"
+
+//PartitionKeyer item 所属的分区，有序队列中相同分区的item按加入的顺序一个一个的处理
+type PartitionKeyer interface {
+	GetPartitionKey() string
+}
+
+//partitionKey 没有实现 PartitionKeyer 的item自己就是一个分区
+func partitionKey(item Itemer) string {
+	if k, ok := item.(PartitionKeyer); ok {
+		return k.GetPartitionKey()
+	}
+	return item.GetID()
+}
+
+//partition 一个分区中等待处理的item
+type partition struct {
+	key   string
+	items *ringBuffer
+	index int //在可以取出的分区堆中的下标，不在堆中时为-1
+}
+
+/*
+keyedStore 按分区保存 Ready 的item，类似 kafka 的分区有序:
+
+1. 分区内按加入的顺序，分区中有item在处理中(busy)时，这个分区的其他item都不能被取出
+2. 不同分区之间互不影响，可以被多个消费者并行处理，按分区队头加入的顺序取出
+3. 处理失败重新变为 Ready 的item放回分区的队头，保证重试时仍然在后面的item之前
+
+只有没有在处理中并且有item的分区才在堆中，所以取出是 O(log 分区数)
+*/
+type keyedStore struct {
+	partitions map[string]*partition
+	ready      []*partition   //可以取出的分区，按队头的序号排列的小顶堆
+	busy       map[string]int //分区中处理中的item个数
+	size       int
+	seq        uint64
+}
+
+var _ itemStore = (*keyedStore)(nil)
+
+func newKeyedStore() *keyedStore {
+	return &keyedStore{
+		partitions: make(map[string]*partition, 0),
+		busy:       make(map[string]int, 0),
+	}
+}
+
+func (s *keyedStore) len() int {
+	return s.size
+}
+
+//push 加入分区的队尾
+func (s *keyedStore) push(e *entry) {
+	s.seq++
+	e.seq = s.seq
+	p := s.partition(partitionKey(e.item))
+	p.items.push(e)
+	s.size++
+	s.update(p)
+}
+
+//pushFront 加入分区的队头
+func (s *keyedStore) pushFront(e *entry) {
+	s.seq++
+	e.seq = s.seq
+	p := s.partition(partitionKey(e.item))
+	p.items.pushFront(e)
+	s.size++
+	s.update(p)
+}
+
+//pop 取出最早的可以取出的item，它所在的分区变为处理中
+func (s *keyedStore) pop() (*entry, bool) {
+	if len(s.ready) == 0 {
+		return nil, false
+	}
+
+	p := heap.Pop((*partitionHeap)(s)).(*partition)
+	e, _ := p.items.pop()
+	s.size--
+	s.busy[p.key]++
+	if p.items.len() == 0 {
+		delete(s.partitions, p.key)
+	}
+	return e, true
+}
+
+func (s *keyedStore) peek() (*entry, bool) {
+	if len(s.ready) == 0 {
+		return nil, false
+	}
+	return s.ready[0].items.peek()
+}
+
+//each 依次遍历每个分区，分区之间没有顺序
+func (s *keyedStore) each(fn func(e *entry) bool) {
+	for _, p := range s.partitions {
+		cont := true
+		p.items.each(func(e *entry) bool {
+			cont = fn(e)
+			return cont
+		})
+		if !cont {
+			return
+		}
+	}
+}
+
+func (s *keyedStore) remove(e *entry) bool {
+
+	p, ok := s.partitions[partitionKey(e.item)]
+	if !ok || !p.items.remove(e) {
+		return false
+	}
+	s.size--
+	if p.items.len() == 0 {
+		delete(s.partitions, p.key)
+	}
+	s.update(p)
+	return true
+}
+
+//release 分区中的一个item处理完了或者重新变为 Ready，返回分区是否有item可以被取出了
+func (s *keyedStore) release(item Itemer) bool {
+
+	key := partitionKey(item)
+	if s.busy[key] <= 1 {
+		delete(s.busy, key)
+	} else {
+		s.busy[key]--
+	}
+
+	p, ok := s.partitions[key]
+	if !ok {
+		return false
+	}
+	s.update(p)
+	return p.index >= 0
+}
+
+func (s *keyedStore) partition(key string) *partition {
+	p, ok := s.partitions[key]
+	if !ok {
+		p = &partition{key: key, items: newRingBuffer(), index: -1}
+		s.partitions[key] = p
+	}
+	return p
+}
+
+//update 分区的队头或者处理状态变化后，调整它在堆中的位置
+func (s *keyedStore) update(p *partition) {
+
+	available := s.busy[p.key] == 0 && p.items.len() > 0
+	switch {
+	case available && p.index < 0:
+		heap.Push((*partitionHeap)(s), p)
+	case available:
+		heap.Fix((*partitionHeap)(s), p.index)
+	case p.index >= 0:
+		heap.Remove((*partitionHeap)(s), p.index)
+	}
+}
+
+//partitionHeap 实现 heap.Interface
+type partitionHeap keyedStore
+
+func (h *partitionHeap) Len() int {
+	return len(h.ready)
+}
+
+func (h *partitionHeap) Less(i, j int) bool {
+	a, _ := h.ready[i].items.peek()
+	b, _ := h.ready[j].items.peek()
+	return a.seq < b.seq
+}
+
+func (h *partitionHeap) Swap(i, j int) {
+	h.ready[i], h.ready[j] = h.ready[j], h.ready[i]
+	h.ready[i].index = i
+	h.ready[j].index = j
+}
+
+func (h *partitionHeap) Push(x interface{}) {
+	p := x.(*partition)
+	p.index = len(h.ready)
+	h.ready = append(h.ready, p)
+}
+
+func (h *partitionHeap) Pop() interface{} {
+	n := len(h.ready)
+	p := h.ready[n-1]
+	h.ready[n-1] = nil //避免内存泄漏
+	h.ready = h.ready[:n-1]
+	p.index = -1
+	return p
+}
+
+/*
+NewOrderedTiQueue 分区有序的队列，item 的分区由 PartitionKeyer 决定，没有实现时每个item自己是一个分区。
+相同分区的item严格按加入的顺序一个一个的投递，前一个 Done 之后下一个才能被 Get，不同分区可以并行处理。
+Len 包含因为分区处理中而暂时不能取出的item
+*/
+func NewOrderedTiQueue(maxCap int) *TiQueue {
+	q := NewTiQueue(maxCap)
+	q.items = newKeyedStore()
+	return q
+}
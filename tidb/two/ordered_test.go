@@ -0,0 +1,222 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-22 11:34:20
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 14:02:26
+ * @FilePath: \tidb\two\ordered_test.go
+ */
+package two
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+)
+
+//PartItem 属于分区 Key 的第 Seq 个item
+type PartItem struct {
+	Key string
+	Seq int
+}
+
+func (p PartItem) GetID() string {
+	return fmt.Sprintf("%v-%v", p.Key, p.Seq)
+}
+
+func (p PartItem) GetPartitionKey() string {
+	return p.Key
+}
+
+//TestOrderedPartition 分区处理中时后面的item不能被取出，其他分区不受影响
+func TestOrderedPartition(t *testing.T) {
+
+	q := NewOrderedTiQueue(0)
+	q.Add(PartItem{"a", 0})
+	q.Add(PartItem{"a", 1})
+	q.Add(PartItem{"b", 0})
+	q.Add(PartItem{"a", 2})
+
+	a0, _, _ := q.Get(false)
+	b0, _, _ := q.Get(false)
+	if a0 != (PartItem{"a", 0}) || b0 != (PartItem{"b", 0}) {
+		t.Errorf("%v %v", a0, b0)
+	}
+	if _, _, err := q.Get(false); !errors.Is(err, ErrEmpty) {
+		t.Errorf("err %v not %v", err, ErrEmpty)
+	}
+	if q.Len() != 2 {
+		t.Errorf("len %v not equal 2", q.Len())
+	}
+
+	q.Done(a0)
+	a1, _, _ := q.Get(false)
+	if a1 != (PartItem{"a", 1}) {
+		t.Errorf("%v not equal a-1", a1)
+	}
+
+	//处理失败放回分区的队头
+	q.Nack(a1, nil)
+	item, _, _ := q.Get(false)
+	if item != (PartItem{"a", 1}) {
+		t.Errorf("%v not equal a-1", item)
+	}
+	q.Done(item)
+	item, _, _ = q.Get(false)
+	if item != (PartItem{"a", 2}) {
+		t.Errorf("%v not equal a-2", item)
+	}
+}
+
+//TestOrderedStatusError 状态不对的item被丢弃后分区被释放，后面的item可以取出
+func TestOrderedStatusError(t *testing.T) {
+
+	q := NewOrderedTiQueue(0)
+	q.Add(PartItem{"a", 0})
+	q.Add(PartItem{"a", 1})
+	delete(q.ItemStatus, PartItem{"a", 0}.GetID())
+
+	if _, _, err := q.Get(false); !errors.Is(err, ErrStatusNotFound) {
+		t.Errorf("err %v not %v", err, ErrStatusNotFound)
+	}
+	item, _, err := q.Get(false)
+	if err != nil || item != (PartItem{"a", 1}) {
+		t.Errorf("item %v err %v", item, err)
+	}
+	if errs := q.CheckInvariants(); len(errs) != 0 {
+		t.Errorf("invariants %v", errs)
+	}
+}
+
+//TestOrderedWakeOnDone 分区中的item处理完后唤醒等待的 Get
+func TestOrderedWakeOnDone(t *testing.T) {
+
+	q := NewOrderedTiQueue(0)
+	q.Add(PartItem{"a", 0})
+	q.Add(PartItem{"a", 1})
+	first, _, _ := q.Get(false)
+
+	got := make(chan Itemer, 0)
+	go func() {
+		item, _, _ := q.Get(true)
+		got <- item
+	}()
+
+	select {
+	case item := <-got:
+		t.Fatalf("get %v before done", item)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.Done(first)
+	select {
+	case item := <-got:
+		if item != (PartItem{"a", 1}) {
+			t.Errorf("%v not equal a-1", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get not woken")
+	}
+
+	//关闭后还有等待分区的item时 Get 继续等待
+	q.Add(PartItem{"a", 2})
+	q.ShutDown()
+	go func() {
+		item, _, _ := q.Get(true)
+		got <- item
+	}()
+	q.Done(PartItem{"a", 1})
+	select {
+	case item := <-got:
+		if item != (PartItem{"a", 2}) {
+			t.Errorf("%v not equal a-2", item)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get not woken")
+	}
+}
+
+//TestOrderedConcurrent 多个消费者并发处理，每个分区严格按顺序一个一个处理，不同分区并行
+func TestOrderedConcurrent(t *testing.T) {
+
+	const keys = 10
+	const perKey = 200
+	q := NewOrderedTiQueue(0)
+
+	producer := sync.WaitGroup{}
+	for k := 0; k < keys; k++ {
+		producer.Add(1)
+		go func(k int) {
+			defer producer.Done()
+			for i := 0; i < perKey; i++ {
+				if err := q.Add(PartItem{fmt.Sprint(k), i}); err != nil {
+					t.Error(err)
+				}
+			}
+		}(k)
+	}
+
+	var mu sync.Mutex
+	next := make(map[string]int, 0)     //分区下一个应该处理的序号
+	running := make(map[string]bool, 0) //分区是否有item正在处理
+	maxParallel, parallel := 0, 0
+
+	consumer := sync.WaitGroup{}
+	for c := 0; c < 8; c++ {
+		consumer.Add(1)
+		go func() {
+			defer consumer.Done()
+			for {
+				item, shutdown, err := q.Get(true)
+				if shutdown {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				p := item.(PartItem)
+
+				mu.Lock()
+				if running[p.Key] {
+					t.Errorf("partition %v processed concurrently", p.Key)
+				}
+				if next[p.Key] != p.Seq {
+					t.Errorf("partition %v got %v want %v", p.Key, p.Seq, next[p.Key])
+				}
+				running[p.Key] = true
+				next[p.Key]++
+				parallel++
+				if parallel > maxParallel {
+					maxParallel = parallel
+				}
+				mu.Unlock()
+
+				time.Sleep(time.Duration(rand.Intn(100)) * time.Microsecond)
+
+				mu.Lock()
+				running[p.Key] = false
+				parallel--
+				mu.Unlock()
+				q.Done(item)
+			}
+		}()
+	}
+
+	producer.Wait()
+	q.ShutDown()
+	consumer.Wait()
+
+	for k := 0; k < keys; k++ {
+		if n := next[fmt.Sprint(k)]; n != perKey {
+			t.Errorf("partition %v processed %v not equal %v", k, n, perKey)
+		}
+	}
+	if maxParallel < 2 {
+		t.Errorf("partitions never processed in parallel")
+	}
+}
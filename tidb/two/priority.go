@@ -0,0 +1,195 @@
+/*
+ * @Description:priority
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-21 09:20:13
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 15:58:21
+ * @FilePath: \tidb\two\priority.go
+ */
+package two
+
+import (
+	"container/heap"
+	"errors"
+	"math"
+	"time"
+)
+
+var ErrNoPriority = errors.New("queue does not support priority") //不是 NewPriorityTiQueue 创建的队列
+
+//itemStore Ready 状态item的存储，不是并发安全的，由TiQueue的锁保护
+type itemStore interface {
+	len() int
+	push(e *entry)
+	pop() (*entry, bool)
+	peek() (*entry, bool)
+	each(fn func(e *entry) bool) //遍历所有item，fn 返回 false 时停止
+	remove(e *entry) bool        //删除任意位置的item，返回是否找到
+}
+
+var _ itemStore = (*ringBuffer)(nil)
+var _ itemStore = (*priorityHeap)(nil)
+
+/*
+priorityHeap 按优先级排列的堆，prio 越大越先被取出，相同优先级按加入的顺序。
+
+为了防止低优先级的item一直被插队(饿死)，每等待 aging 的时间相当于提升一级优先级，
+也就是按 addedAt - prio*aging 从小到大排序。这个值在加入时就确定了，不需要随时间重新调整堆，
+低优先级的item最多等待 优先级差*aging 就会排到新加入的高优先级item前面。aging 小于等于0时不做老化
+*/
+type priorityHeap struct {
+	entries []*entry
+	byID    map[string]*entry //Ready 状态的item，用来提升优先级
+	aging   time.Duration
+	seq     uint64 //加入的序号，排序值相同时先加入的在前面
+}
+
+func newPriorityHeap(aging time.Duration) *priorityHeap {
+	return &priorityHeap{
+		byID:  make(map[string]*entry, 0),
+		aging: aging,
+	}
+}
+
+func (h *priorityHeap) len() int {
+	return len(h.entries)
+}
+
+func (h *priorityHeap) push(e *entry) {
+	h.seq++
+	e.seq = h.seq
+	h.byID[e.item.GetID()] = e
+	heap.Push((*entryHeap)(h), e)
+}
+
+func (h *priorityHeap) pop() (*entry, bool) {
+	if len(h.entries) == 0 {
+		return nil, false
+	}
+	e := heap.Pop((*entryHeap)(h)).(*entry)
+	delete(h.byID, e.item.GetID())
+	return e, true
+}
+
+func (h *priorityHeap) peek() (*entry, bool) {
+	if len(h.entries) == 0 {
+		return nil, false
+	}
+	return h.entries[0], true
+}
+
+//each 按堆中的顺序遍历，不是取出的顺序
+func (h *priorityHeap) each(fn func(e *entry) bool) {
+	for _, e := range h.entries {
+		if !fn(e) {
+			return
+		}
+	}
+}
+
+func (h *priorityHeap) remove(e *entry) bool {
+	if e.index < 0 || e.index >= len(h.entries) || h.entries[e.index] != e {
+		return false
+	}
+	heap.Remove((*entryHeap)(h), e.index)
+	delete(h.byID, e.item.GetID())
+	return true
+}
+
+//raise 把 Ready 状态的item提升到 prio，prio 不比原来高时返回 false
+func (h *priorityHeap) raise(id string, prio int) bool {
+	e, ok := h.byID[id]
+	if !ok || prio <= e.prio {
+		return false
+	}
+	e.prio = prio
+	heap.Fix((*entryHeap)(h), e.index)
+	return true
+}
+
+func (h *priorityHeap) less(a, b *entry) bool {
+
+	if h.aging <= 0 {
+		if a.prio != b.prio {
+			return a.prio > b.prio
+		}
+		return a.seq < b.seq
+	}
+
+	//addedAt - prio*aging 直接计算时 prio*aging 会溢出，改为比较加入时间的差和优先级的差:
+	//a.addedAt - b.addedAt < (a.prio - b.prio)*aging 时 a 排在前面
+	dp := int64(a.prio) - int64(b.prio)
+	overflow := (a.prio >= 0) != (b.prio >= 0) && (dp >= 0) != (a.prio >= 0)
+	if overflow || dp > math.MaxInt64/int64(h.aging) || dp < math.MinInt64/int64(h.aging) {
+		//优先级的差乘上 aging 超过了任何时间差，只看优先级
+		return a.prio > b.prio
+	}
+	dt := a.addedAt.Sub(b.addedAt)
+	if boost := time.Duration(dp) * h.aging; dt != boost {
+		return dt < boost
+	}
+	return a.seq < b.seq
+}
+
+//entryHeap 实现 heap.Interface
+type entryHeap priorityHeap
+
+func (h *entryHeap) Len() int {
+	return len(h.entries)
+}
+
+func (h *entryHeap) Less(i, j int) bool {
+	return (*priorityHeap)(h).less(h.entries[i], h.entries[j])
+}
+
+func (h *entryHeap) Swap(i, j int) {
+	h.entries[i], h.entries[j] = h.entries[j], h.entries[i]
+	h.entries[i].index = i
+	h.entries[j].index = j
+}
+
+func (h *entryHeap) Push(x interface{}) {
+	e := x.(*entry)
+	e.index = len(h.entries)
+	h.entries = append(h.entries, e)
+}
+
+func (h *entryHeap) Pop() interface{} {
+	n := len(h.entries)
+	e := h.entries[n-1]
+	h.entries[n-1] = nil //避免内存泄漏
+	h.entries = h.entries[:n-1]
+	e.index = -1
+	return e
+}
+
+/*
+NewPriorityTiQueue 支持优先级的队列，item 按优先级而不是加入的顺序被取出，去重和状态与 TiQueue 相同。
+aging 为老化的时间，每等待 aging 相当于提升一级优先级，小于等于0时严格按优先级。优先级不会持久化
+*/
+func NewPriorityTiQueue(maxCap int, aging time.Duration) *TiQueue {
+	q := NewTiQueue(maxCap)
+	q.items = newPriorityHeap(aging)
+	return q
+}
+
+/*
+AddWithPriority 按优先级添加item，prio 越大越先被取出，Add 相当于优先级为0。
+item 已经在队列中等待处理时，如果 prio 比原来的高就提升它的优先级，否则返回 item 已经存在的错误
+*/
+func (q *TiQueue) AddWithPriority(item Itemer, prio int) error {
+
+	q.Lock()
+	defer q.Unlock()
+
+	if _, ok := q.items.(*priorityHeap); !ok {
+		return q.opError("add", item.GetID(), ErrNoPriority)
+	}
+
+	err := q.add(item, prio)
+	if err != nil {
+		q.rejected(item, err)
+	}
+	return q.opError("add", item.GetID(), err)
+}
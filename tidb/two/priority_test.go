@@ -0,0 +1,157 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-21 10:55:40
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 15:58:21
+ * @FilePath: \tidb\two\priority_test.go
+ */
+package two
+
+import (
+	"errors"
+	"math"
+	"testing"
+	"time"
+)
+
+func getIDs(t *testing.T, q *TiQueue, n int) []string {
+	var ids []string
+	for i := 0; i < n; i++ {
+		item, _, err := q.Get(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, item.GetID())
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+//TestPriorityOrder 优先级高的先取出，相同优先级按加入顺序
+func TestPriorityOrder(t *testing.T) {
+
+	q := NewPriorityTiQueue(0, 0)
+	q.Add(StringItem("bulk1"))
+	q.AddWithPriority(StringItem("low"), -1)
+	q.Add(StringItem("bulk2"))
+	q.AddWithPriority(StringItem("urgent1"), 10)
+	q.AddWithPriority(StringItem("high"), 5)
+	q.AddWithPriority(StringItem("urgent2"), 10)
+
+	want := []string{"urgent1", "urgent2", "high", "bulk1", "bulk2", "low"}
+	if ids := getIDs(t, q, 6); !equalIDs(ids, want) {
+		t.Errorf("%v not equal %v", ids, want)
+	}
+}
+
+//TestPriorityAging 等待足够久的低优先级item排到新加入的高优先级item前面
+func TestPriorityAging(t *testing.T) {
+
+	q := NewPriorityTiQueue(0, 10*time.Millisecond)
+	q.AddWithPriority(StringItem("old"), 0)
+	time.Sleep(50 * time.Millisecond)
+	q.AddWithPriority(StringItem("high"), 3)
+	q.AddWithPriority(StringItem("urgent"), 100)
+
+	want := []string{"urgent", "old", "high"}
+	if ids := getIDs(t, q, 3); !equalIDs(ids, want) {
+		t.Errorf("%v not equal %v", ids, want)
+	}
+}
+
+//TestPriorityAgingOverflow 优先级很大时 prio*aging 不会溢出，仍然按优先级排列
+func TestPriorityAgingOverflow(t *testing.T) {
+
+	q := NewPriorityTiQueue(0, time.Hour)
+	q.AddWithPriority(StringItem("min"), math.MinInt)
+	q.AddWithPriority(StringItem("low"), -1000)
+	q.Add(StringItem("bulk"))
+	q.AddWithPriority(StringItem("high"), 1<<30)
+	q.AddWithPriority(StringItem("max"), math.MaxInt)
+
+	want := []string{"max", "high", "bulk", "low", "min"}
+	if ids := getIDs(t, q, 5); !equalIDs(ids, want) {
+		t.Errorf("%v not equal %v", ids, want)
+	}
+}
+
+//TestPriorityRaise 重复加入 Ready 的item时提升优先级
+func TestPriorityRaise(t *testing.T) {
+
+	q := NewPriorityTiQueue(2, 0)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+
+	//队列满了也可以提升优先级
+	if err := q.AddWithPriority(StringItem("two"), 1); err != nil {
+		t.Fatal(err)
+	}
+	//优先级没有更高
+	if err := q.AddWithPriority(StringItem("two"), 1); !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+	if err := q.Add(StringItem("one")); !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+	if q.Len() != 2 {
+		t.Errorf("len %v not equal 2", q.Len())
+	}
+
+	item, _, _ := q.Get(false)
+	if item.GetID() != "two" {
+		t.Errorf("%v not equal two", item.GetID())
+	}
+
+	//处理中的item再加入一次，队列中的那个也可以提升优先级
+	q.AddWithPriority(StringItem("two"), 0)
+	if status := q.GetItemStatus(item); status != (Ready<<2)|InProcess {
+		t.Errorf("status %b", status)
+	}
+	q.AddWithPriority(StringItem("two"), 2)
+	item, _, _ = q.Get(false)
+	if item.GetID() != "two" {
+		t.Errorf("%v not equal two", item.GetID())
+	}
+	if status := q.GetItemStatus(item); status != (InProcess<<2)|InProcess {
+		t.Errorf("status %b", status)
+	}
+	if err := q.AddWithPriority(StringItem("two"), 5); !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+}
+
+//TestPriorityRequeue 重新变为 Ready 的item保持原来的优先级
+func TestPriorityRequeue(t *testing.T) {
+
+	q := NewPriorityTiQueue(0, 0)
+	q.AddWithPriority(StringItem("urgent"), 10)
+	q.Add(StringItem("bulk"))
+
+	item, _, _ := q.Get(false)
+	q.Nack(item, nil)
+
+	want := []string{"urgent", "bulk"}
+	if ids := getIDs(t, q, 2); !equalIDs(ids, want) {
+		t.Errorf("%v not equal %v", ids, want)
+	}
+}
+
+func TestNoPriority(t *testing.T) {
+	q := NewTiQueue(2)
+	if err := q.AddWithPriority(StringItem("one"), 1); !errors.Is(err, ErrNoPriority) {
+		t.Errorf("err %v not %v", err, ErrNoPriority)
+	}
+}
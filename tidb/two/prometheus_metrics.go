@@ -0,0 +1,209 @@
+/*
+ * @Description:prometheus metrics
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 13:05:48
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-19 14:18:30
+ * @FilePath: \tidb\two\prometheus_metrics.go
+ */
+package two
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//defaultBuckets 耗时直方图的桶，从10ns到10s，每个桶是上一个的10倍
+var defaultBuckets = []float64{1e-8, 1e-7, 1e-6, 1e-5, 1e-4, 1e-3, 1e-2, 1e-1, 1, 10}
+
+//histogram 简单的累积直方图
+type histogram struct {
+	buckets []float64
+	counts  []uint64 //每个桶的累积个数，和 buckets 一一对应
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+/*
+PrometheusMetrics 以 Prometheus 文本格式输出队列指标的 MetricsProvider，不依赖第三方库
+所有指标都带有 name 标签，多个队列可以共用一个 http 路径分别输出
+*/
+type PrometheusMetrics struct {
+	name string
+
+	mu          sync.Mutex
+	depth       int
+	adds        uint64
+	rejected    map[string]uint64
+	latency     *histogram
+	workDur     *histogram
+	unfinished  float64
+	longestProc float64
+}
+
+var _ MetricsProvider = (*PrometheusMetrics)(nil)
+
+//NewPrometheusMetrics 创建指标，name 为队列的名称
+func NewPrometheusMetrics(name string) *PrometheusMetrics {
+	return &PrometheusMetrics{
+		name:     name,
+		rejected: make(map[string]uint64, 0),
+		latency:  newHistogram(defaultBuckets),
+		workDur:  newHistogram(defaultBuckets),
+	}
+}
+
+func (m *PrometheusMetrics) SetDepth(depth int) {
+	m.mu.Lock()
+	m.depth = depth
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) IncAdds() {
+	m.mu.Lock()
+	m.adds++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) IncRejected(reason string) {
+	m.mu.Lock()
+	m.rejected[reason]++
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ObserveLatency(d time.Duration) {
+	m.mu.Lock()
+	m.latency.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) ObserveWorkDuration(d time.Duration) {
+	m.mu.Lock()
+	m.workDur.observe(d.Seconds())
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) SetUnfinishedWorkSeconds(s float64) {
+	m.mu.Lock()
+	m.unfinished = s
+	m.mu.Unlock()
+}
+
+func (m *PrometheusMetrics) SetLongestRunningProcessorSeconds(s float64) {
+	m.mu.Lock()
+	m.longestProc = s
+	m.mu.Unlock()
+}
+
+//WriteTo 按 Prometheus 文本格式输出所有指标
+func (m *PrometheusMetrics) WriteTo(w io.Writer) (int64, error) {
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	label := fmt.Sprintf("name=%q", m.name)
+
+	writeHeader(cw, "tiqueue_depth", "gauge", "Current depth of the queue.")
+	fmt.Fprintf(cw, "tiqueue_depth{%s} %d\n", label, m.depth)
+
+	writeHeader(cw, "tiqueue_adds_total", "counter", "Total number of adds handled by the queue.")
+	fmt.Fprintf(cw, "tiqueue_adds_total{%s} %d\n", label, m.adds)
+
+	//原因排序后输出，保证每次输出的顺序一致
+	reasons := []string{RejectExceedCap, RejectExist, RejectClosed}
+	for reason := range m.rejected {
+		if reason != RejectExceedCap && reason != RejectExist && reason != RejectClosed {
+			reasons = append(reasons, reason)
+		}
+	}
+	sort.Strings(reasons[3:])
+	writeHeader(cw, "tiqueue_rejected_adds_total", "counter", "Total number of adds rejected by the queue.")
+	for _, reason := range reasons {
+		fmt.Fprintf(cw, "tiqueue_rejected_adds_total{%s,reason=%q} %d\n", label, reason, m.rejected[reason])
+	}
+
+	writeHistogram(cw, "tiqueue_queue_duration_seconds", "How long in seconds an item stays in the queue before being requested.", label, m.latency)
+	writeHistogram(cw, "tiqueue_work_duration_seconds", "How long in seconds processing an item from the queue takes.", label, m.workDur)
+
+	writeHeader(cw, "tiqueue_unfinished_work_seconds", "gauge", "How many seconds of work in progress has not been observed by work duration.")
+	fmt.Fprintf(cw, "tiqueue_unfinished_work_seconds{%s} %s\n", label, formatFloat(m.unfinished))
+
+	writeHeader(cw, "tiqueue_longest_running_processor_seconds", "gauge", "How many seconds the longest running processor has been running.")
+	fmt.Fprintf(cw, "tiqueue_longest_running_processor_seconds{%s} %s\n", label, formatFloat(m.longestProc))
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+//ServeHTTP 作为 /metrics 接口输出
+func (m *PrometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+func writeHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, typ)
+}
+
+func writeHistogram(w io.Writer, name, help, label string, h *histogram) {
+	writeHeader(w, name, "histogram", help)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{%s,le=%q} %d\n", name, label, formatFloat(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, label, h.count)
+	fmt.Fprintf(w, "%s_sum{%s} %s\n", name, label, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, label, h.count)
+}
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//countWriter 统计写入的字节数，遇到错误后不再写入
+type countWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
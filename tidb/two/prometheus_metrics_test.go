@@ -0,0 +1,102 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 15:06:21
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-19 15:40:57
+ * @FilePath: \tidb\two\prometheus_metrics_test.go
+ */
+package two
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPrometheusMetrics(t *testing.T) {
+
+	m := NewPrometheusMetrics("test")
+	m.SetDepth(3)
+	m.IncAdds()
+	m.IncAdds()
+	m.IncRejected(RejectExist)
+	m.ObserveLatency(5 * time.Millisecond)
+	m.ObserveWorkDuration(2 * time.Second)
+	m.ObserveWorkDuration(20 * time.Second)
+	m.SetUnfinishedWorkSeconds(1.5)
+	m.SetLongestRunningProcessorSeconds(1)
+
+	w := &strings.Builder{}
+	n, err := m.WriteTo(w)
+	if err != nil {
+		t.Error(err)
+	}
+	out := w.String()
+	if int(n) != len(out) {
+		t.Errorf("n %v not equal %v", n, len(out))
+	}
+
+	for _, want := range []string{
+		"# TYPE tiqueue_depth gauge\n",
+		`tiqueue_depth{name="test"} 3`,
+		`tiqueue_adds_total{name="test"} 2`,
+		`tiqueue_rejected_adds_total{name="test",reason="exceed_cap"} 0`,
+		`tiqueue_rejected_adds_total{name="test",reason="exist"} 1`,
+		`tiqueue_rejected_adds_total{name="test",reason="closed"} 0`,
+		"# TYPE tiqueue_queue_duration_seconds histogram\n",
+		`tiqueue_queue_duration_seconds_bucket{name="test",le="0.001"} 0`,
+		`tiqueue_queue_duration_seconds_bucket{name="test",le="0.01"} 1`,
+		`tiqueue_queue_duration_seconds_count{name="test"} 1`,
+		`tiqueue_work_duration_seconds_bucket{name="test",le="1"} 0`,
+		`tiqueue_work_duration_seconds_bucket{name="test",le="10"} 1`,
+		`tiqueue_work_duration_seconds_bucket{name="test",le="+Inf"} 2`,
+		`tiqueue_work_duration_seconds_sum{name="test"} 22`,
+		`tiqueue_unfinished_work_seconds{name="test"} 1.5`,
+		`tiqueue_longest_running_processor_seconds{name="test"} 1`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output not contains %v", want)
+		}
+	}
+
+	//作为 http 接口输出
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.String() != out {
+		t.Errorf("http output %v not equal %v", rec.Body.String(), out)
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("content type %v", rec.Header().Get("Content-Type"))
+	}
+
+}
+
+//TestPrometheusMetricsQueue 接入队列
+func TestPrometheusMetricsQueue(t *testing.T) {
+
+	m := NewPrometheusMetrics("queue")
+	q := NewTiQueue(10)
+	q.SetMetricsProvider(m)
+	for v := 0; v < 3; v++ {
+		q.Add(IntItem(v))
+	}
+	item, _, _ := q.Get(false)
+	q.Done(item)
+
+	w := &strings.Builder{}
+	m.WriteTo(w)
+	for _, want := range []string{
+		`tiqueue_depth{name="queue"} 2`,
+		`tiqueue_adds_total{name="queue"} 3`,
+		`tiqueue_queue_duration_seconds_count{name="queue"} 1`,
+		`tiqueue_work_duration_seconds_count{name="queue"} 1`,
+	} {
+		if !strings.Contains(w.String(), want) {
+			t.Errorf("output not contains %v", want)
+		}
+	}
+
+}
@@ -4,33 +4,37 @@
  * @Author: kingeasternsun
  * @Date: 2021-02-25 10:00:18
  * @LastEditors: kingeasternsun
- * @LastEditTime: 2021-02-25 16:53:43
+ * @LastEditTime: 2026-10-28 14:40:51
  * @FilePath: \tidb\two\queue.go
  */
 package two
 
 import (
-	"errors"
+	"context"
+	"sort"
 	"sync"
+	"time"
 )
 
 /*
 ● 支持多个生产者和消费者，允许 item 在处理的同时被重新添加到队列, 同一个 item 在
 并发消费的情况下只能被处理一次
 ● item 在被处理前被添加多次，只会被处理一次
-● item 按添加的顺序被处理，即使是有多个消费者
+● item 按添加的顺序被取出，即使是有多个消费者。多个消费者不保证按顺序处理完成，需要相同分区严格有序时使用 NewOrderedTiQueue
 ● 支持关闭队列通知
 */
 type Queue interface {
-	Add(item interface{})                   // 添加 item 到队列。
-	Get() (item interface{}, shutdown bool) //获取 item。shutdown 如果为 true, 调用者应关闭请求。处理完 item 后应调用 Done 方法。
-	Len() int                               //返回队列长度，不包含正在处理的 item。
-	Done(item interface{})                  //表示 item 被处理完成。
-	ShutDown()                              //关闭队列，不再接收 Add 请求，待队列 item 被处理完后关闭。
-	ShuttingDown() bool                     //队列是否正在关闭。
+	Add(item Itemer) error                                  // 添加 item 到队列。队列已满、已关闭或者 item 已经存在时返回错误。
+	Get(block bool) (item Itemer, shutdown bool, err error) //获取 item。block 为 true 时阻塞到有 item 或者队列关闭。shutdown 如果为 true, 调用者应关闭请求。处理完 item 后应调用 Done 方法。
+	Len() int                                               //返回队列长度，不包含正在处理的 item。
+	Done(item Itemer) error                                 //表示 item 被处理完成。
+	ShutDown()                                              //关闭队列，不再接收 Add 请求，待队列 item 被处理完后关闭。
+	ShuttingDown() bool                                     //队列是否正在关闭。
 
 }
 
+var _ Queue = (*TiQueue)(nil)
+
 type Itemer interface {
 	GetID() string //得到item的唯一标识 用于去重
 }
@@ -85,119 +89,342 @@ const (
 
 */
 type TiQueue struct {
-	MaxCap int //队列最大的item数量
-	Queue  chan Itemer
+	maxCap int //队列最大的item数量，小于等于0表示不限制
 	sync.Mutex
-	ItemStatus map[string]uint8 //记录item的状态 ，
-	done       chan struct{}    //标记是否已经关闭 ,可以返回给消费者或生产者使用
+	ItemStatus map[string]uint8  //记录item的状态 ，
+	items      itemStore         //Ready 状态的item，默认按添加的顺序排列，优先级队列按优先级排列
+	ready      map[string]*entry //Ready 状态的item，按ID索引
+	done       chan struct{}     //标记是否已经关闭 ,可以返回给消费者或生产者使用
 	once       sync.Once
-}
+	notEmpty   chan struct{} //有阻塞的 Get 在等待item时才创建，Add 后关闭它来唤醒等待者
+	notFull    chan struct{} //有 AddContext 在等待空位时才创建，Get 取走item后关闭它来唤醒等待者
+	drained    chan struct{} //有 ShutDownWithDrain 在等待时才创建，所有item都 Done 后关闭它来唤醒等待者
+
+	processing  map[string][]*inflight //处理中的item，相同的item最多两个，最早的在前面
+	inflights   int                    //处理中的item一共有多少个，相同的item分别计数
+	metrics     MetricsProvider
+	metricsOnce sync.Once
+	wal         *walLog //持久化的日志，为nil表示不持久化
+
+	expirations map[string]int //item的租约过期了多少次，item处理完成后清除
+
+	deliveries    map[string]int   //item被 Get 了多少次，处理成功或者放入死信后清除
+	maxDeliveries int              //最多投递多少次，小于等于0表示不限制
+	dlq           *DeadLetterStore //超过投递次数的item
 
-var errExceedCap = errors.New("queue is full")
-var errClosed = errors.New("queue is close")
-var errEmpty = errors.New("queue is empty")
-var errItemNotGet = errors.New("item not get") //item没有Get就Donel
-var errItemExist = errors.New("item exist")    //item 已经存在
+	retryPolicy   RateLimiter //DoneWithResult 处理失败后等待多久重试，nil 表示立即重试
+	dropPermanent bool        //永久错误的item直接丢弃，不放入死信
 
-//NewTiQueue 队列初始化 TODO:maccap为负数时候是否判错
+	merge MergeFunc //AddOrMerge 合并重复的item，nil 时使用 Merger 或者直接替换
+
+	listeners   []*listenerEntry //状态变化的监听，修改时复制一份
+	events      []Event          //还没有回调的事件，解锁时回调
+	dispatching bool             //有 goroutine 正在回调事件
+}
+
+//NewTiQueue 队列初始化，maxCap 小于等于0时队列不限制容量
 func NewTiQueue(maxCap int) *TiQueue {
 
 	return &TiQueue{
-		MaxCap:     maxCap,
-		Queue:      make(chan Itemer, maxCap),
-		ItemStatus: make(map[string]uint8, 0),
-		done:       make(chan struct{}, 0),
+		maxCap:      maxCap,
+		items:       newRingBuffer(),
+		ready:       make(map[string]*entry, 0),
+		ItemStatus:  make(map[string]uint8, 0),
+		done:        make(chan struct{}, 0),
+		processing:  make(map[string][]*inflight, 0),
+		metrics:     noopMetrics{},
+		expirations: make(map[string]int, 0),
+		deliveries:  make(map[string]int, 0),
+		dlq:         newDeadLetterStore(),
 	}
 }
 
+//NewUnboundedTiQueue 不限制容量的队列
+func NewUnboundedTiQueue() *TiQueue {
+	return NewTiQueue(0)
+}
+
 //Add 添加item 到队列
 func (q *TiQueue) Add(item Itemer) error {
 
-	//快速判定，因为队列不可能从关闭变为开启
+	q.Lock()
+	defer q.Unlock()
+
+	err := q.add(item, 0)
+	if err != nil {
+		q.rejected(item, err)
+	}
+	return q.opError("add", item.GetID(), err)
+}
+
+//AddContext 添加item 到队列，队列满了不会直接返回错误，而是阻塞到有空位、队列关闭或者ctx结束
+func (q *TiQueue) AddContext(ctx context.Context, item Itemer) error {
+
+	for {
+		q.Lock()
+		err := q.add(item, 0)
+		if err != ErrExceedCap {
+			if err != nil {
+				q.rejected(item, err)
+			}
+			err = q.opError("add", item.GetID(), err)
+			q.Unlock()
+			return err
+		}
+
+		if q.notFull == nil {
+			q.notFull = make(chan struct{}, 0)
+		}
+		notFull := q.notFull
+		q.Unlock()
+
+		select {
+		case <-notFull:
+		case <-q.done:
+			q.Lock()
+			q.rejected(item, ErrClosed)
+			err := q.opError("add", item.GetID(), ErrClosed)
+			q.Unlock()
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+//add 按优先级添加item，不支持优先级时忽略 prio，调用者需要持有锁
+func (q *TiQueue) add(item Itemer, prio int) error {
+
+	//已经关闭了就不再接收
 	select {
 	case <-q.done:
-		return errClosed
+		return ErrClosed
 	default:
 	}
 
-	if len(q.Queue) == q.MaxCap {
-		return errExceedCap
+	status, ok := q.ItemStatus[item.GetID()]
+	//其他情况下都不可以再进行添加了
+	if ok && status != InProcess {
+		//已经在队列中等待处理，优先级更高时提升优先级
+		if h, isHeap := q.items.(*priorityHeap); isHeap && h.raise(item.GetID(), prio) {
+			q.emit(EventCollapse, item, status, nil)
+			return nil
+		}
+		return ErrItemExist
+	}
+
+	if q.full() {
+		return ErrExceedCap
 	}
 
-	q.Lock()
-	defer q.Unlock()
+	if err := q.journal(walAdd, item); err != nil {
+		return err
+	}
 
-	status, ok := q.ItemStatus[item.GetID()]
 	if !ok {
 		//队列中不存在，就可以直接添加
-		q.Queue <- item
 		q.ItemStatus[item.GetID()] = Ready
-		return nil
-	}
-
-	//已经处理中，而且此刻只有一个相同的item
-	if status == InProcess {
-		q.Queue <- item
+	} else {
+		//已经处理中，而且此刻只有一个相同的item
 		q.ItemStatus[item.GetID()] = (Ready << 2) | InProcess
-		return nil
 	}
+	e := &entry{item: item, addedAt: time.Now(), prio: prio}
+	q.items.push(e)
+	q.ready[item.GetID()] = e
+	q.emit(EventAdd, item, status, nil)
+	q.metrics.IncAdds()
+	q.metrics.SetDepth(q.items.len())
+	q.maybeCompact()
+
+	//唤醒等待的 Get
+	if q.notEmpty != nil {
+		close(q.notEmpty)
+		q.notEmpty = nil
+	}
+	return nil
+}
 
-	//其他情况下都不可以再进行添加了
-	return errItemExist
+//full 队列是否已满，缩容后队列中的item可能会超过容量，调用者需要持有锁
+func (q *TiQueue) full() bool {
+	return q.maxCap > 0 && q.items.len() >= q.maxCap
 }
 
 //Get 从队列中获取item，block 标记是否阻塞读
+//队列关闭并且已经没有item时 shutdown 为 true
 func (q *TiQueue) Get(block bool) (item Itemer, shutdown bool, err error) {
 
-	//阻塞读
-	if block {
-		item, shutdown = <-q.Queue
-	} else {
+	p, shutdown, err := q.get(context.Background(), block, 0)
+	if p != nil {
+		item = p.item
+	}
+	return
+}
+
+//GetContext 阻塞读，直到获取到item、队列关闭并且没有item或者ctx结束
+func (q *TiQueue) GetContext(ctx context.Context) (item Itemer, shutdown bool, err error) {
+
+	p, shutdown, err := q.get(ctx, true, 0)
+	if p != nil {
+		item = p.item
+	}
+	return
+}
+
+//get 获取item，block 为 false 时队列空了直接返回 ErrEmpty，ttl 大于0时同时设置租约
+func (q *TiQueue) get(ctx context.Context, block bool, ttl time.Duration) (p *inflight, shutdown bool, err error) {
+
+	for {
+		q.Lock()
+		e, err := q.pop()
+		if err != nil {
+			q.Unlock()
+			return nil, false, err
+		}
+		if e != nil {
+			p = q.got(e)
+			if ttl > 0 {
+				q.lease(p, ttl)
+			}
+			q.maybeCompact()
+			q.Unlock()
+			return p, false, nil
+		}
+
+		//队列已经关闭，并且item都被取走了，还有等待重试的item或者有序队列中还有等待分区的item时继续等待
+		closed := q.closed()
+		if closed && q.items.len() == 0 && !q.retryPending() {
+			q.Unlock()
+			return nil, true, nil
+		}
+
 		//非阻塞读
+		if !block {
+			err = q.opError("get", "", ErrEmpty)
+			q.Unlock()
+			return nil, false, err
+		}
+
+		if q.notEmpty == nil {
+			q.notEmpty = make(chan struct{}, 0)
+		}
+		notEmpty := q.notEmpty
+		done := q.done
+		if closed {
+			done = nil
+		}
+		q.Unlock()
+
 		select {
-		case item, shutdown = <-q.Queue:
-		default:
-			err = errEmpty
-			shutdown = false
-			return
+		case <-notEmpty:
+		case <-done:
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
 		}
 	}
+}
 
-	q.Lock()
-	defer q.Unlock()
+//pop 取出队头，先记录日志再取出，没有item时返回nil，调用者需要持有锁
+//队头item的状态不对时丢弃它并返回包装了 *StatusError 的错误，后面的item不受影响
+func (q *TiQueue) pop() (*entry, error) {
 
-	//更新状态
-	status, ok := q.ItemStatus[item.GetID()]
+	e, ok := q.items.peek()
 	if !ok {
-		//如果找不到 肯定就有大问题了
-		panic("can not found status of " + item.GetID())
+		return nil, nil
 	}
 
-	if status == Ready {
-		q.ItemStatus[item.GetID()] = InProcess
-		return
+	op := byte(walGet)
+	statusErr := q.checkReady(e.item)
+	if statusErr != nil {
+		op = walRemove
+	}
+	if err := q.journal(op, e.item); err != nil {
+		return nil, q.opError("get", e.item.GetID(), err)
 	}
 
-	//两个相同item的情况 最早的肯定是 InProcess ，最新的肯定是 Ready
-	if status == (Ready<<2)|InProcess {
+	q.items.pop()
+	if q.ready[e.item.GetID()] == e {
+		delete(q.ready, e.item.GetID())
+	}
+	if statusErr != nil {
+		//有序队列取出时分区变为处理中，丢弃的item不会再 Done，需要马上释放分区
+		if ks, ok := q.items.(*keyedStore); ok && ks.release(e.item) && q.notEmpty != nil {
+			close(q.notEmpty)
+			q.notEmpty = nil
+		}
+		q.metrics.SetDepth(q.items.len())
+		return nil, q.opError("get", e.item.GetID(), statusErr)
+	}
+	return e, nil
+}
+
+//got 从队列中取出item后更新状态，返回处理中的记录，调用者需要持有锁
+func (q *TiQueue) got(e *entry) (p *inflight) {
+
+	item := e.item
+	now := time.Now()
+	q.metrics.ObserveLatency(now.Sub(e.addedAt))
+	q.metrics.SetDepth(q.items.len())
+	p = &inflight{item: item, start: now, prio: e.prio}
+	q.processing[item.GetID()] = append(q.processing[item.GetID()], p)
+	q.inflights++
+	q.deliveries[item.GetID()]++
+
+	//空出了位置，唤醒等待的 AddContext
+	if q.notFull != nil {
+		close(q.notFull)
+		q.notFull = nil
+	}
+
+	//更新状态，pop 已经检查过只可能是这两种
+	old := q.ItemStatus[item.GetID()]
+	if old == Ready {
+		q.ItemStatus[item.GetID()] = InProcess
+	} else {
+		//两个相同item的情况 最早的肯定是 InProcess ，最新的肯定是 Ready
 		q.ItemStatus[item.GetID()] = (InProcess << 2) | InProcess
-		return
 	}
+	q.emit(EventGet, item, old, nil)
+	return
+}
 
-	//其他状态 肯定就有大问题了
-	panic(" status error " + item.GetID())
+//SetMaxCap 运行时调整队列容量，n 小于等于0表示不限制
+//缩容时不会丢弃已经在队列中的item，只是在item数量降到容量以下之前拒绝新的 Add
+func (q *TiQueue) SetMaxCap(n int) {
+	q.Lock()
+	defer q.Unlock()
+
+	q.maxCap = n
+
+	//可能有了空位，唤醒等待的 AddContext
+	if !q.full() && q.notFull != nil {
+		close(q.notFull)
+		q.notFull = nil
+	}
+}
 
+//Cap 队列容量，小于等于0表示不限制
+func (q *TiQueue) Cap() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.maxCap
 }
 
 //Len 获取Ready 状态的数据个数
 func (q *TiQueue) Len() int {
-	return len(q.Queue)
+	q.Lock()
+	defer q.Unlock()
+	return q.items.len()
 }
 
 //Done 表示item处理完成了
 func (q *TiQueue) Done(item Itemer) (err error) {
 	q.Lock()
 	defer q.Unlock()
+	return q.opError("done", item.GetID(), q.markDone(item))
+}
+
+//markDone item处理完成，调用者需要持有锁
+func (q *TiQueue) markDone(item Itemer) (err error) {
 
 	//更新状态
 	status, ok := q.ItemStatus[item.GetID()]
@@ -208,19 +435,93 @@ func (q *TiQueue) Done(item Itemer) (err error) {
 
 	//如果没有Get就Done 了
 	if status == Ready {
-		err = errItemNotGet
+		err = ErrItemNotGet
+		return
+	}
+
+	//都在等待重试时不属于任何消费者，重试后重新变为 Ready，不能被 Done
+	p := q.active(item.GetID())
+	if p == nil {
 		return
 	}
+	q.forget(item)
+	return q.finish(item, p)
+}
+
+//finish 处理完成一个处理中的item，p 为nil时表示最早的那个，调用者需要持有锁
+//相同的item两个都在处理中时不管完成的是哪一个，剩下的都是一个处理中的item，所以状态都是右移2bit
+func (q *TiQueue) finish(item Itemer, p *inflight) error {
+	return q.finishAs(EventDone, item, p, nil)
+}
+
+//finishAs 和 finish 一样，typ 和 reason 为记录的事件，调用者需要持有锁
+func (q *TiQueue) finishAs(typ EventType, item Itemer, p *inflight, reason error) error {
+
+	if err := q.journal(walDone, item); err != nil {
+		return err
+	}
+
+	if p = q.removeInflight(item.GetID(), p); p != nil {
+		q.metrics.ObserveWorkDuration(time.Since(p.start))
+		if p.timer != nil {
+			p.timer.Stop()
+		}
+	}
 
-	status = status >> 2
+	old := q.ItemStatus[item.GetID()]
+	status := old >> 2
 	if status == 0 {
 		delete(q.ItemStatus, item.GetID())
+		delete(q.expirations, item.GetID())
 	} else {
 		q.ItemStatus[item.GetID()] = status
 	}
+	q.emit(typ, item, old, reason)
 
-	return
+	q.maybeCompact()
+
+	//队列关闭后 Get 可能在等待重试的item，唤醒它们重新检查
+	if q.closed() && q.notEmpty != nil {
+		close(q.notEmpty)
+		q.notEmpty = nil
+	}
+
+	//所有item都处理完了，唤醒等待的 ShutDownWithDrain
+	if len(q.ItemStatus) == 0 && q.drained != nil {
+		close(q.drained)
+		q.drained = nil
+	}
+
+	return nil
+}
+
+//removeInflight 删除处理中的记录，p 为nil时删除最早的那个，返回被删除的记录，调用者需要持有锁
+func (q *TiQueue) removeInflight(id string, p *inflight) *inflight {
+
+	ps := q.processing[id]
+	i := 0
+	if p != nil {
+		for i = 0; i < len(ps) && ps[i] != p; i++ {
+		}
+	}
+	if i >= len(ps) {
+		return nil
+	}
 
+	removed := ps[i]
+	q.inflights--
+	if len(ps) == 1 {
+		delete(q.processing, id)
+	} else {
+		q.processing[id] = append(ps[:i:i], ps[i+1:]...)
+	}
+
+	//有序队列中分区的下一个item可以被取出了，唤醒等待的 Get
+	if ks, ok := q.items.(*keyedStore); ok && ks.release(removed.item) && q.notEmpty != nil {
+		close(q.notEmpty)
+		q.notEmpty = nil
+	}
+	return removed
 }
 
 //ShutDown 关闭
@@ -230,17 +531,64 @@ func (q *TiQueue) ShutDown() {
 	defer q.Unlock()
 	q.once.Do(func() {
 		close(q.done)
-		close(q.Queue)
+		if len(q.listeners) > 0 {
+			q.events = append(q.events, Event{Type: EventShutDown, At: time.Now()})
+		}
 	})
 
 	return
 }
 
+//ShutDownWithDrain 关闭队列，不再接收 Add，并等待队列中的和处理中的item都被 Done
+//ctx 结束时还有没处理完的item，返回它们的标识和 ctx 的错误
+func (q *TiQueue) ShutDownWithDrain(ctx context.Context) (unfinished []string, err error) {
+
+	q.ShutDown()
+
+	for {
+		q.Lock()
+		if len(q.ItemStatus) == 0 {
+			q.Unlock()
+			return nil, nil
+		}
+
+		if q.drained == nil {
+			q.drained = make(chan struct{}, 0)
+		}
+		drained := q.drained
+		q.Unlock()
+
+		select {
+		case <-drained:
+		case <-ctx.Done():
+			q.Lock()
+			for id := range q.ItemStatus {
+				unfinished = append(unfinished, id)
+			}
+			q.Unlock()
+			sort.Strings(unfinished)
+			return unfinished, ctx.Err()
+		}
+	}
+}
+
+//closed 队列是否已经关闭
+func (q *TiQueue) closed() bool {
+	select {
+	case <-q.done:
+		return true
+	default:
+		return false
+	}
+}
+
 //ShuttingDown 判断是否在关闭中
 func (q *TiQueue) ShuttingDown() bool {
 
 	select {
 	case <-q.done:
+		q.Lock()
+		defer q.Unlock()
 		//所有任务都处理完成了
 		if len(q.ItemStatus) == 0 {
 			return false //已经完全关闭了 ，而不是关闭中
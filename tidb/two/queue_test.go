@@ -4,15 +4,19 @@
  * @Author: kingeasternsun
  * @Date: 2021-02-25 14:57:51
  * @LastEditors: kingeasternsun
- * @LastEditTime: 2021-02-25 16:51:35
+ * @LastEditTime: 2026-10-24 11:29:09
  * @FilePath: \tidb\two\queue_test.go
  */
 package two
 
 import (
+	"context"
+	"errors"
 	"strconv"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 type StringItem string
@@ -67,8 +71,8 @@ func TestReadyStatus(t *testing.T) {
 
 	//测试 重复
 	err := q.Add(StringItem("3"))
-	if err != errItemExist {
-		t.Errorf("err %v not %v", err, errItemExist)
+	if !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
 	}
 
 	//填满队列
@@ -79,8 +83,8 @@ func TestReadyStatus(t *testing.T) {
 
 	//测试溢出
 	err = q.Add(StringItem("full"))
-	if err != errExceedCap {
-		t.Errorf("err %v not %v", err, errExceedCap)
+	if !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
 	}
 
 	isShuting = q.ShuttingDown()
@@ -94,12 +98,14 @@ func TestReadyStatus(t *testing.T) {
 		t.Errorf("shutingdown %v not %v", isShuting, true)
 	}
 
+	cnt := 0
 	for {
-		//非阻塞读
+		//非阻塞读，关闭后队列中剩余的item仍然可以取出
 		item, shutdown, _ := q.Get(false)
 		if shutdown {
 			break
 		}
+		cnt++
 
 		status := q.GetItemStatus(item)
 		if status != InProcess {
@@ -115,6 +121,15 @@ func TestReadyStatus(t *testing.T) {
 
 	}
 
+	if cnt != 7 {
+		t.Errorf("consume %v not equal %v", cnt, 7)
+	}
+
+	isShuting = q.ShuttingDown()
+	if isShuting {
+		t.Errorf("shutingdown %v not %v", isShuting, false)
+	}
+
 }
 
 type IntItem int
@@ -136,8 +151,10 @@ func TestMultiProduceMultiCousumer(t *testing.T) {
 			t.Errorf("%v status %v not equal ready", v, status)
 		}
 	}
-	wg := sync.WaitGroup{}
-	wg.Add(5)
+	producer := sync.WaitGroup{}
+	producer.Add(3)
+	consumer := sync.WaitGroup{}
+	consumer.Add(2)
 	go func() {
 
 		for v := 0; v < 1000; v++ {
@@ -145,12 +162,8 @@ func TestMultiProduceMultiCousumer(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			status := q.GetItemStatus(IntItem(v))
-			if status != Ready {
-				t.Errorf("%v status %v not equal ready", v, status)
-			}
 		}
-		wg.Done()
+		producer.Done()
 
 	}()
 
@@ -160,12 +173,8 @@ func TestMultiProduceMultiCousumer(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			status := q.GetItemStatus(IntItem(v))
-			if status != Ready {
-				t.Errorf("%v status %v not equal ready", v, status)
-			}
 		}
-		wg.Done()
+		producer.Done()
 
 	}()
 
@@ -175,25 +184,28 @@ func TestMultiProduceMultiCousumer(t *testing.T) {
 			if err != nil {
 				t.Error(err)
 			}
-			status := q.GetItemStatus(IntItem(v))
-			if status != Ready {
-				t.Errorf("%v status %v not equal ready", v, status)
-			}
 		}
-		wg.Done()
+		producer.Done()
 
 	}()
 
+	mx := sync.Mutex{}
+	cnt := 0
+
 	//构建2个 消费者
 	for i := 0; i < 2; i++ {
 		go func() {
 
 			for {
-				//非阻塞读
-				item, shutdown, _ := q.Get(false)
+				//阻塞读，队列关闭并且取完后退出
+				item, shutdown, err := q.Get(true)
 				if shutdown {
 					break
 				}
+				if err != nil {
+					t.Error(err)
+					break
+				}
 
 				status := q.GetItemStatus(item)
 				if status != InProcess {
@@ -202,19 +214,25 @@ func TestMultiProduceMultiCousumer(t *testing.T) {
 
 				//完成任务
 				q.Done(item)
-				status = q.GetItemStatus(item)
-				if status != NotExist {
-					t.Errorf("%v status %v not equal NotExist ", item, status)
-				}
+				mx.Lock()
+				cnt++
+				mx.Unlock()
 
 			}
 
-			wg.Done()
+			consumer.Done()
 
 		}()
 	}
 
-	wg.Wait()
+	//生产者都完成后关闭队列，消费者处理完剩下的item后退出
+	producer.Wait()
+	q.ShutDown()
+	consumer.Wait()
+
+	if cnt != 4000 {
+		t.Errorf("consume %v not equal %v", cnt, 4000)
+	}
 
 }
 
@@ -323,6 +341,7 @@ func TestInproduceAdd2(t *testing.T) {
 func TestProduceOnce(t *testing.T) {
 
 	numMap := make(map[string]struct{}, 0)
+	mx := sync.Mutex{}
 
 	q := NewTiQueue(1000)
 	for v := 0; v < 1000; v++ {
@@ -344,17 +363,19 @@ func TestProduceOnce(t *testing.T) {
 		go func() {
 
 			for {
-				//非阻塞读
-				item, shutdown, _ := q.Get(false)
-				if shutdown {
+				//非阻塞读，队列空了就退出
+				item, shutdown, err := q.Get(false)
+				if shutdown || errors.Is(err, ErrEmpty) {
 					break
 				}
 
+				mx.Lock()
 				if _, ok := numMap[item.GetID()]; ok {
 					t.Errorf("%v has produce", item.GetID())
 				}
 
 				numMap[item.GetID()] = struct{}{}
+				mx.Unlock()
 
 				status := q.GetItemStatus(item)
 				if status != InProcess {
@@ -377,6 +398,10 @@ func TestProduceOnce(t *testing.T) {
 
 	wg.Wait()
 
+	if len(numMap) != 1000 {
+		t.Errorf("produce %v not equal %v", len(numMap), 1000)
+	}
+
 }
 
 //TestAddMultiBugProduceOnce item 在被处理前被添加多次，只会被处理一次
@@ -388,8 +413,8 @@ func TestAddMultiBugProduceOnce(t *testing.T) {
 	}
 
 	err = q.Add(IntItem(1))
-	if err != errItemExist {
-		t.Errorf(" err %v not equal %v", err, errItemExist)
+	if !errors.Is(err, ErrItemExist) {
+		t.Errorf(" err %v not equal %v", err, ErrItemExist)
 	}
 
 	item, _, err := q.Get(true)
@@ -402,17 +427,18 @@ func TestAddMultiBugProduceOnce(t *testing.T) {
 	}
 
 	_, _, err = q.Get(false)
-	if err != errEmpty {
+	if !errors.Is(err, ErrEmpty) {
 		t.Error(err)
 	}
 
 }
 
 //item 按添加的顺序被处理，即使是有多个消费者
+//每个消费者依次拿到的item一定是按添加顺序递增的
 func TestInOrder(t *testing.T) {
 
-	res := make([]string, 0)
-	mx := sync.Mutex{}
+	consumerNumber := 8
+	res := make([][]int, consumerNumber)
 	q := NewTiQueue(1000)
 	for v := 0; v < 1000; v++ {
 		err := q.Add(IntItem(v))
@@ -424,23 +450,20 @@ func TestInOrder(t *testing.T) {
 			t.Errorf("%v status %v not equal ready", v, status)
 		}
 	}
-	consumerNumber := 8
 	wg := sync.WaitGroup{}
 	wg.Add(consumerNumber)
 
 	//构建8个 消费者
 	for i := 0; i < consumerNumber; i++ {
-		go func() {
+		go func(i int) {
 
 			for {
-				//非阻塞读
-				item, shutdown, _ := q.Get(false)
-				if shutdown {
+				//非阻塞读，队列空了就退出
+				item, shutdown, err := q.Get(false)
+				if shutdown || errors.Is(err, ErrEmpty) {
 					break
 				}
-				mx.Lock()
-				res = append(res, item.GetID())
-				mx.Unlock()
+				res[i] = append(res[i], int(item.(IntItem)))
 
 				status := q.GetItemStatus(item)
 				if status != InProcess {
@@ -458,16 +481,23 @@ func TestInOrder(t *testing.T) {
 
 			wg.Done()
 
-		}()
+		}(i)
 	}
 
 	wg.Wait()
 
-	for i := 1; i < len(res); i++ {
-		if res[i] < res[i-1] {
-			t.Errorf("%v less %v", res[i], res[i-1])
+	total := 0
+	for _, r := range res {
+		total += len(r)
+		for i := 1; i < len(r); i++ {
+			if r[i] < r[i-1] {
+				t.Errorf("%v less %v", r[i], r[i-1])
+			}
 		}
 	}
+	if total != 1000 {
+		t.Errorf("consume %v not equal %v", total, 1000)
+	}
 }
 
 //TestCloseNotify 支持关闭队列通知
@@ -514,3 +544,315 @@ func TestCloseNotify(t *testing.T) {
 	wg2.Wait()
 
 }
+
+//TestGetContext 阻塞读可以被ctx取消，也会在队列关闭后返回
+func TestGetContext(t *testing.T) {
+
+	q := NewTiQueue(2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, shutdown, err := q.GetContext(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("err %v not %v", err, context.DeadlineExceeded)
+	}
+	if shutdown {
+		t.Errorf("shutdown %v not %v", shutdown, false)
+	}
+
+	q.Add(StringItem("one"))
+	item, shutdown, err := q.GetContext(context.Background())
+	if err != nil || shutdown {
+		t.Errorf("err %v shutdown %v", err, shutdown)
+	}
+	if status := q.GetItemStatus(item); status != InProcess {
+		t.Errorf("%v status %v not equal InProcess ", item, status)
+	}
+
+	//关闭后阻塞的GetContext返回
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.ShutDown()
+	}()
+	_, shutdown, err = q.GetContext(context.Background())
+	if err != nil || !shutdown {
+		t.Errorf("err %v shutdown %v", err, shutdown)
+	}
+
+}
+
+//TestAddContext 队列满了阻塞等待空位，而不是直接返回错误
+func TestAddContext(t *testing.T) {
+
+	q := NewTiQueue(1)
+	err := q.AddContext(context.Background(), StringItem("one"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	//重复的item直接返回，不需要等待
+	err = q.AddContext(context.Background(), StringItem("one"))
+	if !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+
+	//等待超时
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err = q.AddContext(ctx, StringItem("two"))
+	if err != context.DeadlineExceeded {
+		t.Errorf("err %v not %v", err, context.DeadlineExceeded)
+	}
+
+	//消费者取走后就可以加入了
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		err := q.AddContext(context.Background(), StringItem("two"))
+		if err != nil {
+			t.Error(err)
+		}
+		wg.Done()
+	}()
+
+	time.Sleep(20 * time.Millisecond)
+	item, _, _ := q.Get(true)
+	if item.GetID() != "one" {
+		t.Errorf("%v not equal %v", item.GetID(), "one")
+	}
+	wg.Wait()
+
+	if status := q.GetItemStatus(StringItem("two")); status != Ready {
+		t.Errorf("status %v not equal Ready ", status)
+	}
+
+	//关闭后等待的AddContext返回
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		q.ShutDown()
+	}()
+	err = q.AddContext(context.Background(), StringItem("three"))
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+
+}
+
+//TestAddContextBackpressure 多个生产者通过AddContext写入小容量队列，不会丢失item
+func TestAddContextBackpressure(t *testing.T) {
+
+	q := NewTiQueue(4)
+	producer := sync.WaitGroup{}
+	producer.Add(4)
+	for i := 0; i < 4; i++ {
+		go func(i int) {
+			for v := i * 100; v < (i+1)*100; v++ {
+				err := q.AddContext(context.Background(), IntItem(v))
+				if err != nil {
+					t.Error(err)
+				}
+			}
+			producer.Done()
+		}(i)
+	}
+
+	go func() {
+		producer.Wait()
+		q.ShutDown()
+	}()
+
+	cnt := 0
+	for {
+		item, shutdown, err := q.GetContext(context.Background())
+		if shutdown || err != nil {
+			break
+		}
+		cnt++
+		q.Done(item)
+	}
+
+	if cnt != 400 {
+		t.Errorf("consume %v not equal %v", cnt, 400)
+	}
+
+}
+
+//TestUnbounded 不限制容量的队列
+func TestUnbounded(t *testing.T) {
+
+	q := NewUnboundedTiQueue()
+	for v := 0; v < 10000; v++ {
+		err := q.Add(IntItem(v))
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if q.Len() != 10000 {
+		t.Errorf("len %v not equal %v", q.Len(), 10000)
+	}
+
+	err := q.Add(IntItem(0))
+	if !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+
+	for v := 0; v < 10000; v++ {
+		item, _, err := q.Get(false)
+		if err != nil {
+			t.Error(err)
+		}
+		if item != IntItem(v) {
+			t.Errorf("%v not equal %v", item, v)
+		}
+		q.Done(item)
+	}
+
+}
+
+//TestSetMaxCap 运行时调整容量，不会丢失item，顺序和状态都保持不变
+func TestSetMaxCap(t *testing.T) {
+
+	q := NewTiQueue(4)
+	for v := 0; v < 4; v++ {
+		q.Add(IntItem(v))
+	}
+	err := q.Add(IntItem(4))
+	if !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+
+	//扩容后唤醒等待的 AddContext
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		err := q.AddContext(context.Background(), IntItem(4))
+		if err != nil {
+			t.Error(err)
+		}
+		wg.Done()
+	}()
+	time.Sleep(20 * time.Millisecond)
+	q.SetMaxCap(6)
+	wg.Wait()
+	if q.Cap() != 6 || q.Len() != 5 {
+		t.Errorf("cap %v len %v", q.Cap(), q.Len())
+	}
+
+	item, _, _ := q.Get(false)
+	if item != IntItem(0) {
+		t.Errorf("%v not equal %v", item, 0)
+	}
+
+	//缩容后已经在队列中的item不会丢失，但是不能再添加
+	q.SetMaxCap(2)
+	if q.Len() != 4 {
+		t.Errorf("len %v not equal %v", q.Len(), 4)
+	}
+	err = q.Add(IntItem(0))
+	if !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+	if status := q.GetItemStatus(IntItem(0)); status != InProcess {
+		t.Errorf("status %v not equal InProcess", status)
+	}
+
+	for v := 1; v < 5; v++ {
+		item, _, _ := q.Get(false)
+		if item != IntItem(v) {
+			t.Errorf("%v not equal %v", item, v)
+		}
+		if status := q.GetItemStatus(item); status != InProcess {
+			t.Errorf("status %v not equal InProcess", status)
+		}
+	}
+
+	//降到容量以下之后可以继续添加
+	err = q.Add(IntItem(0))
+	if err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(IntItem(0)); status != (Ready<<2)|InProcess {
+		t.Errorf("status %v not equal (Ready<<2)|InProcess", status)
+	}
+
+}
+
+//TestShutDownWithDrain 关闭后等待队列中的和处理中的item都处理完
+func TestShutDownWithDrain(t *testing.T) {
+
+	q := NewTiQueue(10)
+	for v := 0; v < 5; v++ {
+		q.Add(IntItem(v))
+	}
+	first, _, _ := q.Get(false)
+
+	res := make(chan error, 1)
+	go func() {
+		unfinished, err := q.ShutDownWithDrain(context.Background())
+		if len(unfinished) != 0 {
+			t.Errorf("unfinished %v not empty", unfinished)
+		}
+		res <- err
+	}()
+
+	//不再接收 Add
+	<-q.GetCloseNotify()
+	err := q.Add(IntItem(10))
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+
+	//消费者继续处理剩下的item
+	cnt := 0
+	for {
+		item, shutdown, _ := q.Get(true)
+		if shutdown {
+			break
+		}
+		cnt++
+		q.Done(item)
+	}
+	if cnt != 4 {
+		t.Errorf("consume %v not equal %v", cnt, 4)
+	}
+
+	select {
+	case <-res:
+		t.Errorf("drain return before %v done", first)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	q.Done(first)
+	err = <-res
+	if err != nil {
+		t.Error(err)
+	}
+
+}
+
+//TestShutDownWithDrainTimeout 超时返回还没处理完的item
+func TestShutDownWithDrainTimeout(t *testing.T) {
+
+	q := NewTiQueue(10)
+	for v := 0; v < 3; v++ {
+		q.Add(IntItem(v))
+	}
+	q.Get(false)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	unfinished, err := q.ShutDownWithDrain(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("err %v not %v", err, context.DeadlineExceeded)
+	}
+	if strings.Join(unfinished, ",") != "0,1,2" {
+		t.Errorf("unfinished %v not equal %v", unfinished, []string{"0", "1", "2"})
+	}
+
+	//没有item的队列直接返回
+	unfinished, err = NewTiQueue(1).ShutDownWithDrain(ctx)
+	if err != nil || len(unfinished) != 0 {
+		t.Errorf("unfinished %v err %v", unfinished, err)
+	}
+
+}
@@ -0,0 +1,218 @@
+/*
+ * @Description:rate limiter
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 09:12:40
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 16:14:37
+ * @FilePath: \tidb\two\rate_limiter.go
+ */
+package two
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+//RateLimiter 限速器，决定处理失败的item要等待多久才能重新加入队列
+type RateLimiter interface {
+	When(item Itemer) time.Duration //返回item需要等待的时间，每调用一次记为一次重试
+	Forget(item Itemer)             //item处理成功了，不再追踪它的重试记录
+	NumRequeues(item Itemer) int    //item已经重试了多少次
+}
+
+//ItemExponentialFailureRateLimiter 按item做指数退避，等待时间为 baseDelay*2^<重试次数>，最多不超过 maxDelay
+type ItemExponentialFailureRateLimiter struct {
+	mu        sync.Mutex
+	failures  map[string]int
+	baseDelay time.Duration
+	maxDelay  time.Duration
+}
+
+//NewItemExponentialFailureRateLimiter 创建指数退避限速器
+func NewItemExponentialFailureRateLimiter(baseDelay, maxDelay time.Duration) *ItemExponentialFailureRateLimiter {
+
+	return &ItemExponentialFailureRateLimiter{
+		failures:  make(map[string]int, 0),
+		baseDelay: baseDelay,
+		maxDelay:  maxDelay,
+	}
+}
+
+//When 返回等待时间，同时重试次数加1
+func (r *ItemExponentialFailureRateLimiter) When(item Itemer) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	exp := r.failures[item.GetID()]
+	r.failures[item.GetID()] = exp + 1
+
+	//先用float计算，防止溢出
+	backoff := float64(r.baseDelay.Nanoseconds()) * math.Pow(2, float64(exp))
+	if backoff > math.MaxInt64 {
+		return r.maxDelay
+	}
+
+	d := time.Duration(backoff)
+	if d > r.maxDelay {
+		return r.maxDelay
+	}
+	return d
+}
+
+//Forget 清除item的重试记录
+func (r *ItemExponentialFailureRateLimiter) Forget(item Itemer) {
+	r.mu.Lock()
+	delete(r.failures, item.GetID())
+	r.mu.Unlock()
+}
+
+//NumRequeues 获取item的重试次数
+func (r *ItemExponentialFailureRateLimiter) NumRequeues(item Itemer) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failures[item.GetID()]
+}
+
+//ItemFixedDelayRateLimiter 每次重试都等待固定的时间，delay 为0时立即重试
+type ItemFixedDelayRateLimiter struct {
+	mu       sync.Mutex
+	failures map[string]int
+	delay    time.Duration
+}
+
+//NewItemFixedDelayRateLimiter 创建固定间隔限速器
+func NewItemFixedDelayRateLimiter(delay time.Duration) *ItemFixedDelayRateLimiter {
+
+	return &ItemFixedDelayRateLimiter{
+		failures: make(map[string]int, 0),
+		delay:    delay,
+	}
+}
+
+//When 返回固定的等待时间，同时重试次数加1
+func (r *ItemFixedDelayRateLimiter) When(item Itemer) time.Duration {
+	r.mu.Lock()
+	r.failures[item.GetID()]++
+	r.mu.Unlock()
+	return r.delay
+}
+
+//Forget 清除item的重试记录
+func (r *ItemFixedDelayRateLimiter) Forget(item Itemer) {
+	r.mu.Lock()
+	delete(r.failures, item.GetID())
+	r.mu.Unlock()
+}
+
+//NumRequeues 获取item的重试次数
+func (r *ItemFixedDelayRateLimiter) NumRequeues(item Itemer) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failures[item.GetID()]
+}
+
+//BucketRateLimiter 全局令牌桶限速，不区分item，用于限制整体的重试速度
+type BucketRateLimiter struct {
+	mu     sync.Mutex
+	qps    float64   //每秒产生的令牌数
+	burst  int       //桶的容量
+	tokens float64   //当前令牌数，可以为负数，表示已经被预定的令牌
+	last   time.Time //上一次计算令牌的时间
+}
+
+//NewBucketRateLimiter 创建令牌桶限速器，初始时桶是满的，qps 小于等于0时不限速
+func NewBucketRateLimiter(qps float64, burst int) *BucketRateLimiter {
+
+	return &BucketRateLimiter{
+		qps:    qps,
+		burst:  burst,
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+//When 预定一个令牌，返回需要等待到令牌可用的时间
+func (r *BucketRateLimiter) When(item Itemer) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	//不产生令牌时等待时间是无穷大(除以0)，当作不限速
+	if r.qps <= 0 {
+		return 0
+	}
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.qps
+	if r.tokens > float64(r.burst) {
+		r.tokens = float64(r.burst)
+	}
+	r.last = now
+
+	r.tokens--
+	if r.tokens >= 0 {
+		return 0
+	}
+
+	//令牌不够，欠下的令牌需要等待补齐
+	return time.Duration(-r.tokens / r.qps * float64(time.Second))
+}
+
+//Forget 令牌桶不追踪item
+func (r *BucketRateLimiter) Forget(item Itemer) {
+}
+
+//NumRequeues 令牌桶不追踪item
+func (r *BucketRateLimiter) NumRequeues(item Itemer) int {
+	return 0
+}
+
+//MaxOfRateLimiter 组合多个限速器，取等待时间最长的那个
+type MaxOfRateLimiter struct {
+	limiters []RateLimiter
+}
+
+//NewMaxOfRateLimiter 创建组合限速器
+func NewMaxOfRateLimiter(limiters ...RateLimiter) *MaxOfRateLimiter {
+	return &MaxOfRateLimiter{limiters: limiters}
+}
+
+//When 每个限速器都要调用，保证各自的计数都正确
+func (r *MaxOfRateLimiter) When(item Itemer) time.Duration {
+	var ret time.Duration
+	for _, limiter := range r.limiters {
+		d := limiter.When(item)
+		if d > ret {
+			ret = d
+		}
+	}
+	return ret
+}
+
+//Forget 所有限速器都清除item
+func (r *MaxOfRateLimiter) Forget(item Itemer) {
+	for _, limiter := range r.limiters {
+		limiter.Forget(item)
+	}
+}
+
+//NumRequeues 取最大的重试次数
+func (r *MaxOfRateLimiter) NumRequeues(item Itemer) int {
+	ret := 0
+	for _, limiter := range r.limiters {
+		n := limiter.NumRequeues(item)
+		if n > ret {
+			ret = n
+		}
+	}
+	return ret
+}
+
+//DefaultRateLimiter 默认限速器 单个item从5ms开始指数退避，最多1000s，整体限制为10qps，突发100
+func DefaultRateLimiter() RateLimiter {
+	return NewMaxOfRateLimiter(
+		NewItemExponentialFailureRateLimiter(5*time.Millisecond, 1000*time.Second),
+		NewBucketRateLimiter(10, 100),
+	)
+}
@@ -0,0 +1,137 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 10:45:03
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 16:14:37
+ * @FilePath: \tidb\two\rate_limiter_test.go
+ */
+package two
+
+import (
+	"testing"
+	"time"
+)
+
+func TestItemExponentialFailureRateLimiter(t *testing.T) {
+
+	limiter := NewItemExponentialFailureRateLimiter(time.Millisecond, time.Second)
+
+	for i, want := range []time.Duration{1, 2, 4, 8, 16} {
+		d := limiter.When(StringItem("one"))
+		if d != want*time.Millisecond {
+			t.Errorf("%v when %v not equal %v", i, d, want*time.Millisecond)
+		}
+	}
+
+	if n := limiter.NumRequeues(StringItem("one")); n != 5 {
+		t.Errorf("requeues %v not equal %v", n, 5)
+	}
+
+	//不同的item互不影响
+	if d := limiter.When(StringItem("two")); d != time.Millisecond {
+		t.Errorf("when %v not equal %v", d, time.Millisecond)
+	}
+
+	limiter.Forget(StringItem("one"))
+	if n := limiter.NumRequeues(StringItem("one")); n != 0 {
+		t.Errorf("requeues %v not equal %v", n, 0)
+	}
+	if d := limiter.When(StringItem("one")); d != time.Millisecond {
+		t.Errorf("when %v not equal %v", d, time.Millisecond)
+	}
+
+	//不超过最大值
+	for i := 0; i < 100; i++ {
+		limiter.When(StringItem("three"))
+	}
+	if d := limiter.When(StringItem("three")); d != time.Second {
+		t.Errorf("when %v not equal %v", d, time.Second)
+	}
+
+}
+
+func TestItemFixedDelayRateLimiter(t *testing.T) {
+
+	limiter := NewItemFixedDelayRateLimiter(10 * time.Millisecond)
+
+	for i := 0; i < 3; i++ {
+		if d := limiter.When(StringItem("one")); d != 10*time.Millisecond {
+			t.Errorf("%v when %v not equal %v", i, d, 10*time.Millisecond)
+		}
+	}
+	if n := limiter.NumRequeues(StringItem("one")); n != 3 {
+		t.Errorf("requeues %v not equal %v", n, 3)
+	}
+
+	limiter.Forget(StringItem("one"))
+	if n := limiter.NumRequeues(StringItem("one")); n != 0 {
+		t.Errorf("requeues %v not equal %v", n, 0)
+	}
+
+}
+
+func TestBucketRateLimiter(t *testing.T) {
+
+	limiter := NewBucketRateLimiter(1, 3)
+
+	//突发容量内不需要等待
+	for i := 0; i < 3; i++ {
+		if d := limiter.When(IntItem(i)); d != 0 {
+			t.Errorf("%v when %v not equal 0", i, d)
+		}
+	}
+
+	//令牌用完了，要等大约1s
+	d := limiter.When(IntItem(3))
+	if d <= 900*time.Millisecond || d > time.Second {
+		t.Errorf("when %v not about %v", d, time.Second)
+	}
+
+	//再预定一个，要等大约2s
+	d = limiter.When(IntItem(4))
+	if d <= 1900*time.Millisecond || d > 2*time.Second {
+		t.Errorf("when %v not about %v", d, 2*time.Second)
+	}
+
+}
+
+//TestBucketRateLimiterNoLimit qps 小于等于0时不限速
+func TestBucketRateLimiterNoLimit(t *testing.T) {
+
+	for _, qps := range []float64{0, -1} {
+		limiter := NewBucketRateLimiter(qps, 1)
+		for i := 0; i < 10; i++ {
+			if d := limiter.When(IntItem(i)); d != 0 {
+				t.Errorf("qps %v: %v when %v not equal 0", qps, i, d)
+			}
+		}
+	}
+
+}
+
+func TestMaxOfRateLimiter(t *testing.T) {
+
+	limiter := NewMaxOfRateLimiter(
+		NewItemExponentialFailureRateLimiter(time.Millisecond, time.Second),
+		NewItemExponentialFailureRateLimiter(10*time.Millisecond, 20*time.Millisecond),
+	)
+
+	for i, want := range []time.Duration{10, 20, 20, 20, 20, 32} {
+		d := limiter.When(StringItem("one"))
+		if d != want*time.Millisecond {
+			t.Errorf("%v when %v not equal %v", i, d, want*time.Millisecond)
+		}
+	}
+
+	if n := limiter.NumRequeues(StringItem("one")); n != 6 {
+		t.Errorf("requeues %v not equal %v", n, 6)
+	}
+
+	limiter.Forget(StringItem("one"))
+	if n := limiter.NumRequeues(StringItem("one")); n != 0 {
+		t.Errorf("requeues %v not equal %v", n, 0)
+	}
+
+}
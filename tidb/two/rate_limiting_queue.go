@@ -0,0 +1,45 @@
+/*
+ * @Description:rate limiting queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 10:03:26
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-18 14:35:02
+ * @FilePath: \tidb\two\rate_limiting_queue.go
+ */
+package two
+
+/*
+RateLimitingQueue 在DelayingQueue的基础上支持限速重新入队
+消费者处理item失败后调用 AddRateLimited，item 会在限速器给出的时间后重新加入队列，
+重新加入时仍然走TiQueue的Add，所以 ItemStatus 的去重逻辑不受影响。
+处理成功后调用 Forget 清除重试记录。
+*/
+type RateLimitingQueue struct {
+	*DelayingQueue
+	limiter RateLimiter
+}
+
+//NewRateLimitingQueue 创建限速队列
+func NewRateLimitingQueue(maxCap int, limiter RateLimiter) *RateLimitingQueue {
+
+	return &RateLimitingQueue{
+		DelayingQueue: NewDelayingQueue(maxCap, defaultDelayPrecision),
+		limiter:       limiter,
+	}
+}
+
+//AddRateLimited 等待限速器允许后再把item加入队列
+func (q *RateLimitingQueue) AddRateLimited(item Itemer) error {
+	return q.AddAfter(item, q.limiter.When(item))
+}
+
+//Forget item处理成功，清除重试记录
+func (q *RateLimitingQueue) Forget(item Itemer) {
+	q.limiter.Forget(item)
+}
+
+//NumRequeues item 已经重试了多少次
+func (q *RateLimitingQueue) NumRequeues(item Itemer) int {
+	return q.limiter.NumRequeues(item)
+}
@@ -0,0 +1,114 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 11:05:17
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:30:00
+ * @FilePath: \tidb\two\rate_limiting_queue_test.go
+ */
+package two
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+//TestAddRateLimited 处理失败的item按指数退避重新加入队列
+func TestAddRateLimited(t *testing.T) {
+
+	q := NewRateLimitingQueue(4, NewItemExponentialFailureRateLimiter(20*time.Millisecond, time.Second))
+	err := q.Add(StringItem("one"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	item, _, err := q.Get(true)
+	if err != nil {
+		t.Error(err)
+	}
+
+	//第一次失败，20ms后重新入队
+	q.Done(item)
+	start := time.Now()
+	err = q.AddRateLimited(item)
+	if err != nil {
+		t.Error(err)
+	}
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+
+	item, _, err = q.Get(true)
+	if err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("requeue after %v less than %v", d, 20*time.Millisecond)
+	}
+
+	//第二次失败，40ms后重新入队
+	q.Done(item)
+	start = time.Now()
+	q.AddRateLimited(item)
+	item, _, err = q.Get(true)
+	if err != nil {
+		t.Error(err)
+	}
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("requeue after %v less than %v", d, 40*time.Millisecond)
+	}
+
+	if n := q.NumRequeues(item); n != 2 {
+		t.Errorf("requeues %v not equal %v", n, 2)
+	}
+
+	//处理成功
+	q.Done(item)
+	q.Forget(item)
+	if n := q.NumRequeues(item); n != 0 {
+		t.Errorf("requeues %v not equal %v", n, 0)
+	}
+
+}
+
+//TestAddRateLimitedDedup 重新入队时item已经在队列中，只会保留一个
+func TestAddRateLimitedDedup(t *testing.T) {
+
+	q := NewRateLimitingQueue(4, NewItemExponentialFailureRateLimiter(10*time.Millisecond, time.Second))
+	q.AddRateLimited(StringItem("one"))
+	err := q.Add(StringItem("one"))
+	if err != nil {
+		t.Error(err)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 1 {
+		t.Errorf("len %v not equal 1", q.Len())
+	}
+	status := q.GetItemStatus(StringItem("one"))
+	if status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+
+}
+
+//TestAddRateLimitedShutDown 关闭后还没有到期的item不再入队
+func TestAddRateLimitedShutDown(t *testing.T) {
+
+	q := NewRateLimitingQueue(4, NewItemExponentialFailureRateLimiter(20*time.Millisecond, time.Second))
+	q.AddRateLimited(StringItem("one"))
+	q.ShutDown()
+
+	time.Sleep(50 * time.Millisecond)
+	if q.Len() != 0 {
+		t.Errorf("len %v not equal 0", q.Len())
+	}
+
+	err := q.AddRateLimited(StringItem("two"))
+	if !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+
+}
@@ -0,0 +1,81 @@
+/*
+ * @Description:remove
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-23 10:03:16
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-25 11:40:20
+ * @FilePath: \tidb\two\remove.go
+ */
+package two
+
+/*
+Remove 从队列中删除等待处理的item，比如item对应的资源已经被删除了，返回是否删除了。
+
+1. 只有等待处理的item 0001 -> 删除，同时清除投递次数等记录
+2. 处理中的item又被加入了一次 0110 -> 0010，只删除队列中的那个，处理中的不受影响
+
+只有处理中的item时不会删除，由消费者 Done
+*/
+func (q *TiQueue) Remove(id string) (removed bool, err error) {
+
+	q.Lock()
+	defer q.Unlock()
+	defer func() { err = q.opError("remove", id, err) }()
+
+	e, ok := q.ready[id]
+	if !ok {
+		return false, nil
+	}
+
+	if err = q.journal(walRemove, e.item); err != nil {
+		return false, err
+	}
+
+	q.items.remove(e)
+	delete(q.ready, id)
+	q.metrics.SetDepth(q.items.len())
+
+	status := q.ItemStatus[id]
+	if status == Ready {
+		delete(q.ItemStatus, id)
+		delete(q.expirations, id)
+		q.forget(e.item)
+	} else {
+		q.ItemStatus[id] = status & InProcess
+	}
+	q.emit(EventRemove, e.item, status, nil)
+	q.maybeCompact()
+
+	//有了空位，唤醒等待的 AddContext
+	if q.notFull != nil {
+		close(q.notFull)
+		q.notFull = nil
+	}
+	//所有item都处理完了，唤醒等待的 ShutDownWithDrain
+	if len(q.ItemStatus) == 0 && q.drained != nil {
+		close(q.drained)
+		q.drained = nil
+	}
+	return true, nil
+}
+
+//Contains 队列中是否有这个item，等待处理和处理中的都算
+func (q *TiQueue) Contains(id string) bool {
+	q.Lock()
+	defer q.Unlock()
+	_, ok := q.ItemStatus[id]
+	return ok
+}
+
+//Peek 查看下一个会被 Get 取出的item，不取出，没有可以取出的item时 ok 为 false
+func (q *TiQueue) Peek() (item Itemer, ok bool) {
+	q.Lock()
+	defer q.Unlock()
+
+	e, ok := q.items.peek()
+	if !ok {
+		return nil, false
+	}
+	return e.item, true
+}
@@ -0,0 +1,157 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-23 11:18:02
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-23 12:06:35
+ * @FilePath: \tidb\two\remove_test.go
+ */
+package two
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemove(t *testing.T) {
+
+	q := NewTiQueue(3)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+	q.Add(StringItem("three"))
+
+	if item, ok := q.Peek(); !ok || item.GetID() != "one" {
+		t.Errorf("peek %v %v", item, ok)
+	}
+
+	removed, err := q.Remove("two")
+	if err != nil || !removed {
+		t.Fatalf("removed %v err %v", removed, err)
+	}
+	if q.Contains("two") || q.Len() != 2 {
+		t.Errorf("contains %v len %v", q.Contains("two"), q.Len())
+	}
+	if removed, _ := q.Remove("two"); removed {
+		t.Error("removed twice")
+	}
+
+	item, _, _ := q.Get(false)
+	if item.GetID() != "one" {
+		t.Errorf("%v not equal one", item.GetID())
+	}
+	//只有处理中的item不会删除
+	if removed, _ := q.Remove("one"); removed {
+		t.Error("removed in process item")
+	}
+	if !q.Contains("one") {
+		t.Error("one not contained")
+	}
+
+	//处理中的item又被加入了一次，只删除队列中的那个
+	q.Add(StringItem("one"))
+	removed, _ = q.Remove("one")
+	if !removed {
+		t.Error("one not removed")
+	}
+	if status := q.GetItemStatus(item); status != InProcess {
+		t.Errorf("status %b not equal InProcess", status)
+	}
+
+	item, _, _ = q.Get(false)
+	if item.GetID() != "three" {
+		t.Errorf("%v not equal three", item.GetID())
+	}
+	if _, ok := q.Peek(); ok {
+		t.Error("peek empty queue")
+	}
+}
+
+//TestRemoveWake 删除后唤醒等待空位的 AddContext 和等待处理完的 ShutDownWithDrain
+func TestRemoveWake(t *testing.T) {
+
+	q := NewTiQueue(1)
+	q.Add(StringItem("one"))
+
+	done := make(chan error, 0)
+	go func() {
+		done <- q.AddContext(context.Background(), StringItem("two"))
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Remove("one")
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		q.Remove("two")
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ids, err := q.ShutDownWithDrain(ctx); err != nil {
+		t.Errorf("unfinished %v err %v", ids, err)
+	}
+}
+
+//TestRemoveStores 优先级队列和有序队列也可以删除
+func TestRemoveStores(t *testing.T) {
+
+	pq := NewPriorityTiQueue(0, 0)
+	for i := 0; i < 10; i++ {
+		pq.AddWithPriority(IntItem(i), i)
+	}
+	pq.Remove("9")
+	pq.Remove("4")
+	if item, _ := pq.Peek(); item != IntItem(8) {
+		t.Errorf("peek %v not equal 8", item)
+	}
+	want := []string{"8", "7", "6", "5", "3", "2", "1", "0"}
+	if ids := getIDs(t, pq, 8); !equalIDs(ids, want) {
+		t.Errorf("%v not equal %v", ids, want)
+	}
+
+	oq := NewOrderedTiQueue(0)
+	oq.Add(PartItem{"a", 0})
+	oq.Add(PartItem{"a", 1})
+	oq.Add(PartItem{"b", 0})
+	oq.Remove("a-0")
+	want = []string{"a-1", "b-0"}
+	if ids := getIDs(t, oq, 2); !equalIDs(ids, want) {
+		t.Errorf("%v not equal %v", ids, want)
+	}
+	oq.Remove("b-0")
+	if oq.Len() != 0 {
+		t.Errorf("len %v not equal 0", oq.Len())
+	}
+}
+
+//TestRemoveDurable 删除的item重启后不会恢复
+func TestRemoveDurable(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "queue.wal")
+	q, err := NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(Task{"one", 1})
+	q.Add(Task{"two", 2})
+	q.Get(false)
+	q.Add(Task{"one", 3})
+	q.Remove("one")
+	q.Remove("two")
+	q.Close()
+
+	q, err = NewDurableTiQueue(10, path, JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+	//处理中的 one 会恢复
+	items := drain(q)
+	if len(items) != 1 || items[0] != (Task{"one", 1}) {
+		t.Errorf("items %v", items)
+	}
+}
@@ -0,0 +1,597 @@
+/*
+ * @Description:resp
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-26 15:10:33
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 13:05:47
+ * @FilePath: \tidb\two\resp.go
+ */
+package two
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrRESPProtocol = errors.New("resp protocol error") //收到的数据不符合 RESP 协议
+	ErrServerClosed = errors.New("server closed")       //RESPServer 已经关闭
+	ErrConnClosed   = errors.New("connection closed")   //取出item的连接断开了，作为 Nack 的原因
+)
+
+const (
+	maxRESPBulk   = 64 << 20    //一个参数最大的长度
+	maxRESPArgs   = 1024 * 1024 //一条命令最多的参数个数
+	maxRESPInline = 64 << 10    //一行最大的长度，包括内联命令
+)
+
+/*
+RESPServer 用 Redis 协议(RESP)访问 TiQueue，任何 Redis 客户端都可以作为生产者和消费者：
+
+	QADD item              加入队列，成功返回 OK
+	QGET [timeout]         取出item，没有 timeout 时不等待，timeout 为秒数，0表示一直等待。
+	                       没有item或者等待超时返回 nil，队列关闭并且没有item时返回 SHUTDOWN 错误
+	QDONE id               item 处理完成，成功返回 OK
+	QLEN                   Ready 状态的item个数
+	QSTATUS id             item 的状态，为 ItemStatus 的2个bit编码，不存在时为0
+	PING / QUIT
+
+item 用 Codec 解码和编码，只需要唯一标识时使用 RawCodec。
+连接断开时，这个连接取出但还没有 QDONE 的item按 Nack 处理，重新加入队列或者放入死信，不会一直处于处理中；
+QGET 的回复没有发送出去时item直接重新加入队列，不算投递次数。
+失败时返回的错误以原因开头，比如 EXIST、EXCEED_CAP、CLOSED、NOT_GET，其他错误为 ERR
+*/
+type RESPServer struct {
+	q     *TiQueue
+	codec Codec
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[net.Conn]struct{}
+	closed   bool
+	ctx      context.Context //关闭时结束，用于唤醒阻塞的 QGET
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+//NewRESPServer 新建 RESPServer，调用 Serve 或者 ListenAndServe 开始服务
+func NewRESPServer(q *TiQueue, codec Codec) *RESPServer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &RESPServer{
+		q:      q,
+		codec:  codec,
+		conns:  make(map[net.Conn]struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+//ListenAndServe 监听 addr 并服务，直到 Close
+func (s *RESPServer) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+//Serve 在 l 上接收连接，直到 Close，Close 后返回 ErrServerClosed
+func (s *RESPServer) Serve(l net.Listener) error {
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			conn.Close()
+			return ErrServerClosed
+		}
+		s.conns[conn] = struct{}{}
+		s.wg.Add(1)
+		s.mu.Unlock()
+
+		go s.serveConn(conn)
+	}
+}
+
+//Addr 监听的地址，还没有开始服务时返回nil
+func (s *RESPServer) Addr() net.Addr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+//Close 停止接收连接并关闭所有连接，阻塞的 QGET 返回错误，不会关闭队列
+func (s *RESPServer) Close() error {
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	s.cancel()
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+
+	s.wg.Wait()
+	return err
+}
+
+//respConn 一个连接的状态，只在这个连接的 goroutine 中使用
+type respConn struct {
+	fetched map[string][]*Lease //取出了还没有 QDONE 的item，相同的item最早的在前面
+}
+
+//drop 去掉这个连接最早取出的item
+func (c *respConn) drop(id string) {
+	if ls := c.fetched[id]; len(ls) > 1 {
+		c.fetched[id] = ls[1:]
+	} else {
+		delete(c.fetched, id)
+	}
+}
+
+func (s *RESPServer) serveConn(conn net.Conn) {
+
+	c := &respConn{fetched: make(map[string][]*Lease)}
+	defer func() {
+		//取出了还没有完成的item重新加入队列
+		for _, ls := range c.fetched {
+			for _, l := range ls {
+				s.q.nackLease(l, ErrConnClosed)
+			}
+		}
+		conn.Close()
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		s.wg.Done()
+	}()
+
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if errors.Is(err, ErrRESPProtocol) {
+				writeRESP(w, respError("ERR", err.Error()))
+				w.Flush()
+			}
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		quit := strings.EqualFold(args[0], "QUIT")
+		reply := s.exec(c, args)
+		writeRESP(w, reply)
+		item, fetched := reply.(respItem)
+		if err := w.Flush(); err != nil {
+			//取出的item没有发送出去，重新加入队列
+			if fetched {
+				s.q.releaseLease(item.lease)
+			}
+			return
+		}
+		if fetched {
+			id := item.lease.Item.GetID()
+			c.fetched[id] = append(c.fetched[id], item.lease)
+		}
+		if quit {
+			return
+		}
+	}
+}
+
+//respItem QGET 取出的item，发送失败时需要重新加入队列
+type respItem struct {
+	lease *Lease //没有设置租约，只用来找到这次投递
+	data  []byte
+}
+
+//exec 执行命令，返回的回复为 string(简单字符串)、int64、[]byte(bulk)、nil、respItem 或者 *RESPError
+func (s *RESPServer) exec(c *respConn, args []string) interface{} {
+
+	cmd := strings.ToUpper(args[0])
+	argc := map[string][2]int{
+		"PING":    {1, 2},
+		"QUIT":    {1, 1},
+		"QADD":    {2, 2},
+		"QGET":    {1, 2},
+		"QDONE":   {2, 2},
+		"QLEN":    {1, 1},
+		"QSTATUS": {2, 2},
+	}
+	n, ok := argc[cmd]
+	if !ok {
+		return respError("ERR", fmt.Sprintf("unknown command '%v'", args[0]))
+	}
+	if len(args) < n[0] || len(args) > n[1] {
+		return respError("ERR", fmt.Sprintf("wrong number of arguments for '%v' command", args[0]))
+	}
+
+	switch cmd {
+	case "PING":
+		if len(args) == 2 {
+			return []byte(args[1])
+		}
+		return "PONG"
+	case "QUIT":
+		return "OK"
+	case "QADD":
+		item, err := s.codec.Decode([]byte(args[1]))
+		if err != nil {
+			return respError("ERR", err.Error())
+		}
+		if err := s.q.Add(item); err != nil {
+			return queueRESPError(err)
+		}
+		return "OK"
+	case "QGET":
+		return s.get(args[1:])
+	case "QDONE":
+		//优先完成这个连接取出的，已经被其他连接完成时按唯一标识处理
+		if ls := c.fetched[args[1]]; len(ls) > 0 {
+			err := s.q.DoneLease(ls[0])
+			if err == nil || errors.Is(err, ErrLeaseExpired) {
+				c.drop(args[1])
+			}
+			if err == nil {
+				return "OK"
+			}
+			if !errors.Is(err, ErrLeaseExpired) {
+				return queueRESPError(err)
+			}
+		}
+		item := s.q.itemByID(args[1])
+		if item == nil {
+			//已经完成了，幂等处理
+			return "OK"
+		}
+		if err := s.q.Done(item); err != nil {
+			return queueRESPError(err)
+		}
+		return "OK"
+	case "QLEN":
+		return int64(s.q.Len())
+	case "QSTATUS":
+		return int64(s.q.GetItemStatus(RawItem(args[1])))
+	}
+	return nil
+}
+
+//get 执行 QGET
+func (s *RESPServer) get(args []string) interface{} {
+
+	var lease *Lease
+	var shutdown bool
+	var err error
+	if len(args) == 0 {
+		lease, shutdown, err = s.q.getLease(context.Background(), false, 0)
+		if errors.Is(err, ErrEmpty) {
+			return nil
+		}
+	} else {
+		secs, perr := strconv.ParseFloat(args[0], 64)
+		if perr != nil || secs < 0 {
+			return respError("ERR", "timeout is not a valid number")
+		}
+		ctx := s.ctx
+		if secs > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, time.Duration(secs*float64(time.Second)))
+			defer cancel()
+		}
+		lease, shutdown, err = s.q.getLease(ctx, true, 0)
+		if err == context.DeadlineExceeded {
+			return nil
+		}
+	}
+
+	switch {
+	case shutdown:
+		return respError("SHUTDOWN", "queue is shut down")
+	case err != nil:
+		return queueRESPError(err)
+	}
+
+	data, err := s.codec.Encode(lease.Item)
+	if err != nil {
+		s.q.releaseLease(lease)
+		return respError("ERR", err.Error())
+	}
+	return respItem{lease: lease, data: data}
+}
+
+//itemByID 根据唯一标识找到队列中的item，优先处理中的，不存在时返回nil
+func (q *TiQueue) itemByID(id string) Itemer {
+	q.Lock()
+	defer q.Unlock()
+
+	if ps := q.processing[id]; len(ps) > 0 {
+		return ps[0].item
+	}
+	if e, ok := q.ready[id]; ok {
+		return e.item
+	}
+	return nil
+}
+
+//RESPError RESP 的错误回复，Code 为第一个单词，比如 ERR、EXIST
+type RESPError struct {
+	Code string
+	Msg  string
+}
+
+func (e *RESPError) Error() string {
+	return e.Code + " " + e.Msg
+}
+
+//Unwrap 队列的错误还原成对应的 sentinel 错误，可以用 errors.Is 判断
+func (e *RESPError) Unwrap() error {
+	for _, v := range wireErrors {
+		if strings.EqualFold(v.reason, e.Code) {
+			return v.err
+		}
+	}
+	return nil
+}
+
+func respError(code string, msg string) *RESPError {
+	return &RESPError{Code: code, Msg: msg}
+}
+
+//queueRESPError 队列的错误转换成 RESP 的错误，已知的原因作为 Code
+func queueRESPError(err error) *RESPError {
+	we, _ := toWire(err)
+	code := "ERR"
+	if we.Reason != "" {
+		code = strings.ToUpper(we.Reason)
+	}
+	return respError(code, err.Error())
+}
+
+//readCommand 读取一条命令，支持 RESP 数组和空格分隔的内联命令
+func readCommand(r *bufio.Reader) ([]string, error) {
+
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return strings.Fields(line), nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+	if err != nil || n < -1 || n > maxRESPArgs {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrRESPProtocol)
+	}
+	//和 Redis 一样，*0 和 *-1 当作空命令
+	if n <= 0 {
+		return nil, nil
+	}
+	//参数个数由客户端决定，不按它预先分配
+	args := make([]string, 0, 8)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, fmt.Errorf("%w: expected '$', got '%.1s'", ErrRESPProtocol, line)
+		}
+		data, err := readBulk(r, line)
+		if err != nil {
+			return nil, err
+		}
+		if data == nil {
+			return nil, fmt.Errorf("%w: null bulk in command", ErrRESPProtocol)
+		}
+		args = append(args, string(data))
+	}
+	return args, nil
+}
+
+//readLine 读取一行，去掉结尾的 \r\n，超过 maxRESPInline 时返回协议错误
+func readLine(r *bufio.Reader) (string, error) {
+
+	var line []byte
+	for {
+		frag, err := r.ReadSlice('\n')
+		if len(line)+len(frag) > maxRESPInline {
+			return "", fmt.Errorf("%w: too big line", ErrRESPProtocol)
+		}
+		line = append(line, frag...)
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r"), nil
+}
+
+//readBulk 读取 $ 开头的 bulk 字符串，长度为-1时返回nil
+func readBulk(r *bufio.Reader, header string) ([]byte, error) {
+
+	n, err := strconv.Atoi(header[1:])
+	if err != nil || n < -1 || n > maxRESPBulk {
+		return nil, fmt.Errorf("%w: invalid bulk length", ErrRESPProtocol)
+	}
+	if n == -1 {
+		return nil, nil
+	}
+	//按实际收到的数据分配内存，不能只凭长度就分配
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(n)+2); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	data := buf.Bytes()
+	if data[n] != '\r' || data[n+1] != '\n' {
+		return nil, fmt.Errorf("%w: bulk not end with CRLF", ErrRESPProtocol)
+	}
+	return data[:n], nil
+}
+
+//writeRESP 按类型写回复
+func writeRESP(w *bufio.Writer, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case string:
+		w.WriteString("+" + v + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case []byte:
+		writeBulk(w, v)
+	case respItem:
+		writeBulk(w, v.data)
+	case *RESPError:
+		//错误信息中不能有换行
+		msg := strings.NewReplacer("\r", " ", "\n", " ").Replace(v.Msg)
+		w.WriteString("-" + v.Code + " " + msg + "\r\n")
+	case []string:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, s := range v {
+			writeBulk(w, []byte(s))
+		}
+	}
+}
+
+func writeBulk(w *bufio.Writer, data []byte) {
+	w.WriteString("$" + strconv.Itoa(len(data)) + "\r\n")
+	w.Write(data)
+	w.WriteString("\r\n")
+}
+
+/*
+RESPClient 最小的 RESP 客户端，用于测试和简单的场景，不是并发安全的。
+Do 返回的回复为 string(简单字符串)、int64、[]byte(bulk)、nil 或者 []interface{}，错误回复返回 *RESPError
+*/
+type RESPClient struct {
+	conn net.Conn
+	r    *bufio.Reader
+	w    *bufio.Writer
+}
+
+//DialRESP 连接 RESP 服务
+func DialRESP(addr string) (*RESPClient, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &RESPClient{conn: conn, r: bufio.NewReader(conn), w: bufio.NewWriter(conn)}, nil
+}
+
+//Close 关闭连接
+func (c *RESPClient) Close() error {
+	return c.conn.Close()
+}
+
+//Do 发送命令并读取回复
+func (c *RESPClient) Do(args ...string) (interface{}, error) {
+	writeRESP(c.w, args)
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(c.r)
+}
+
+//readReply 读取一个回复
+func readReply(r *bufio.Reader) (interface{}, error) {
+
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%w: empty reply", ErrRESPProtocol)
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		code, msg, _ := strings.Cut(line[1:], " ")
+		return nil, &RESPError{Code: code, Msg: msg}
+	case ':':
+		n, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid integer", ErrRESPProtocol)
+		}
+		return n, nil
+	case '$':
+		data, err := readBulk(r, line)
+		if err != nil || data == nil {
+			return nil, err
+		}
+		return data, nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid multibulk length", ErrRESPProtocol)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := readReply(r)
+			var re *RESPError
+			if err != nil && !errors.As(err, &re) {
+				return nil, err
+			}
+			if re != nil {
+				v = re
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	}
+	return nil, fmt.Errorf("%w: unknown reply type '%c'", ErrRESPProtocol, line[0])
+}
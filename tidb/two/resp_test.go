@@ -0,0 +1,265 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-26 17:52:06
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 13:05:47
+ * @FilePath: \tidb\two\resp_test.go
+ */
+package two
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newRESPServer(t *testing.T, q *TiQueue, codec Codec) (*RESPServer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewRESPServer(q, codec)
+	go s.Serve(l)
+	t.Cleanup(func() { s.Close() })
+	return s, l.Addr().String()
+}
+
+func dialRESP(t *testing.T, addr string) *RESPClient {
+	c, err := DialRESP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+func expectReply(t *testing.T, c *RESPClient, want interface{}, args ...string) {
+	t.Helper()
+	v, err := c.Do(args...)
+	if err != nil {
+		t.Fatalf("%v: %v", args, err)
+	}
+	if b, ok := v.([]byte); ok {
+		v = string(b)
+	}
+	if v != want {
+		t.Errorf("%v: %#v not equal %#v", args, v, want)
+	}
+}
+
+//TestRESPCommands 命令和 TiQueue 的语义一致
+func TestRESPCommands(t *testing.T) {
+
+	q := NewTiQueue(2)
+	_, addr := newRESPServer(t, q, RawCodec{})
+	c := dialRESP(t, addr)
+
+	expectReply(t, c, "PONG", "PING")
+	expectReply(t, c, "OK", "QADD", "one")
+	expectReply(t, c, "OK", "qadd", "two")
+	expectReply(t, c, int64(2), "QLEN")
+	expectReply(t, c, int64(Ready), "QSTATUS", "one")
+
+	_, err := c.Do("QADD", "one")
+	var re *RESPError
+	if !errors.As(err, &re) || re.Code != "EXIST" || !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v", err)
+	}
+	if _, err := c.Do("QADD", "three"); !errors.Is(err, ErrExceedCap) {
+		t.Errorf("err %v not %v", err, ErrExceedCap)
+	}
+	if _, err := c.Do("QDONE", "two"); !errors.Is(err, ErrItemNotGet) {
+		t.Errorf("err %v not %v", err, ErrItemNotGet)
+	}
+
+	expectReply(t, c, "one", "QGET")
+	expectReply(t, c, int64(InProcess), "QSTATUS", "one")
+	expectReply(t, c, "OK", "QADD", "one")
+	expectReply(t, c, int64((Ready<<2)|InProcess), "QSTATUS", "one")
+	expectReply(t, c, "OK", "QDONE", "one")
+	expectReply(t, c, int64(Ready), "QSTATUS", "one")
+	expectReply(t, c, "two", "QGET", "1")
+	expectReply(t, c, "one", "QGET")
+	expectReply(t, c, nil, "QGET")
+	expectReply(t, c, "OK", "QDONE", "two")
+	expectReply(t, c, "OK", "QDONE", "two")
+	expectReply(t, c, int64(NotExist), "QSTATUS", "two")
+
+	if _, err := c.Do("QGET", "soon"); !errors.As(err, &re) || re.Code != "ERR" {
+		t.Errorf("err %v", err)
+	}
+	if _, err := c.Do("QADD"); !errors.As(err, &re) || re.Code != "ERR" {
+		t.Errorf("err %v", err)
+	}
+	if _, err := c.Do("SET", "a", "b"); !errors.As(err, &re) || re.Code != "ERR" {
+		t.Errorf("err %v", err)
+	}
+	expectReply(t, c, "OK", "QUIT")
+}
+
+//TestRESPBlockingGet 阻塞的 QGET 等到新加入的item，超时返回 nil，队列关闭后返回 SHUTDOWN
+func TestRESPBlockingGet(t *testing.T) {
+
+	q := NewTiQueue(0)
+	_, addr := newRESPServer(t, q, JSONCodec[Task]{})
+	consumer := dialRESP(t, addr)
+	producer := dialRESP(t, addr)
+
+	start := time.Now()
+	expectReply(t, consumer, nil, "QGET", "0.05")
+	if d := time.Since(start); d < 50*time.Millisecond {
+		t.Errorf("return after %v", d)
+	}
+
+	added := make(chan struct{})
+	go func() {
+		defer close(added)
+		time.Sleep(30 * time.Millisecond)
+		producer.Do("QADD", `{"ID":"one","Data":1}`)
+	}()
+	expectReply(t, consumer, `{"ID":"one","Data":1}`, "QGET", "0")
+	<-added
+
+	q.ShutDown()
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		time.Sleep(30 * time.Millisecond)
+		producer.Do("QDONE", "one")
+	}()
+	_, err := consumer.Do("QGET", "0")
+	var re *RESPError
+	if !errors.As(err, &re) || re.Code != "SHUTDOWN" {
+		t.Errorf("err %v", err)
+	}
+	<-finished
+	if _, err := producer.Do("QADD", `{"ID":"two"}`); !errors.Is(err, ErrClosed) {
+		t.Errorf("err %v not %v", err, ErrClosed)
+	}
+}
+
+//TestRESPInline 支持空格分隔的内联命令，比如 telnet
+func TestRESPInline(t *testing.T) {
+
+	q := NewTiQueue(0)
+	_, addr := newRESPServer(t, q, RawCodec{})
+
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	conn.Write([]byte("QADD one\r\nQLEN\r\n"))
+	for _, want := range []string{"+OK", ":1"} {
+		line, err := readLine(r)
+		if err != nil || line != want {
+			t.Errorf("line %q err %v not %q", line, err, want)
+		}
+	}
+}
+
+//TestRESPClose 关闭服务后阻塞的 QGET 返回，队列不受影响
+func TestRESPClose(t *testing.T) {
+
+	q := NewTiQueue(0)
+	s, addr := newRESPServer(t, q, RawCodec{})
+	c := dialRESP(t, addr)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.Do("QGET", "0")
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Close()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("no error")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("get not return")
+	}
+	if q.ShuttingDown() {
+		t.Error("queue shut down")
+	}
+}
+
+//TestRESPMalformed 不合法的请求返回协议错误并关闭连接，不影响服务，空命令忽略
+func TestRESPMalformed(t *testing.T) {
+
+	q := NewTiQueue(0)
+	_, addr := newRESPServer(t, q, RawCodec{})
+
+	for _, req := range []string{
+		"*-2\r\n",
+		"*abc\r\n",
+		"*2\r\n$-5\r\n",
+		"*1\r\n$3\r\nQLENxx",
+		strings.Repeat("Q", maxRESPInline+1) + "\r\n",
+	} {
+		conn, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write([]byte(req))
+		line, err := readLine(bufio.NewReader(conn))
+		conn.Close()
+		if err != nil || !strings.HasPrefix(line, "-ERR "+ErrRESPProtocol.Error()) {
+			t.Errorf("%.20q: line %q err %v", req, line, err)
+		}
+	}
+
+	//*0 和 *-1 是空命令，之后的命令正常执行
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("*0\r\n*-1\r\n*1\r\n$4\r\nPING\r\n"))
+	if line, err := readLine(bufio.NewReader(conn)); err != nil || line != "+PONG" {
+		t.Errorf("line %q err %v", line, err)
+	}
+}
+
+//TestRESPDisconnect 连接断开后取出了还没有 QDONE 的item重新加入队列
+func TestRESPDisconnect(t *testing.T) {
+
+	q := NewTiQueue(0)
+	_, addr := newRESPServer(t, q, RawCodec{})
+	c, err := DialRESP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Do("QADD", "one")
+	c.Do("QADD", "two")
+	expectReply(t, c, "one", "QGET")
+	expectReply(t, c, "two", "QGET")
+	expectReply(t, c, "OK", "QDONE", "two")
+	c.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for q.GetItemStatus(RawItem("one")) != Ready {
+		if time.Now().After(deadline) {
+			t.Fatalf("status %04b", q.GetItemStatus(RawItem("one")))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if q.NumDeliveries(RawItem("one")) != 1 || q.Contains("two") {
+		t.Errorf("deliveries %v", q.NumDeliveries(RawItem("one")))
+	}
+
+	//其他连接可以取出并完成
+	c2 := dialRESP(t, addr)
+	expectReply(t, c2, "one", "QGET")
+	expectReply(t, c2, "OK", "QDONE", "one")
+	expectReply(t, c2, int64(NotExist), "QSTATUS", "one")
+}
@@ -0,0 +1,165 @@
+/*
+ * @Description:retry
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-20 16:18:45
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:32:02
+ * @FilePath: \tidb\two\retry.go
+ */
+package two
+
+import (
+	"errors"
+	"time"
+)
+
+//PermanentError 标记不需要重试的错误，DoneWithResult 遇到时直接丢弃或者放入死信
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return "permanent: " + e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+//Permanent 把 err 标记为永久错误，err 为nil时返回nil
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{Err: err}
+}
+
+//IsPermanent err 链上是否有 PermanentError
+func IsPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
+
+/*
+SetRetryPolicy 设置 DoneWithResult 处理失败后的重试策略，每次失败调用 limiter.When 得到等待时间，处理成功后 Forget。
+nil 表示立即重试，固定间隔用 NewItemFixedDelayRateLimiter，指数退避用 NewItemExponentialFailureRateLimiter
+*/
+func (q *TiQueue) SetRetryPolicy(limiter RateLimiter) {
+	q.Lock()
+	q.retryPolicy = limiter
+	q.Unlock()
+}
+
+//SetDropPermanent 设置永久错误的item是直接丢弃还是放入死信，默认放入死信
+func (q *TiQueue) SetDropPermanent(drop bool) {
+	q.Lock()
+	q.dropPermanent = drop
+	q.Unlock()
+}
+
+/*
+DoneWithResult 结束item的处理并根据结果决定后续:
+
+1. result 为nil，处理成功，等同于 Done，同时清除投递次数和重试记录
+2. result 为永久错误(见 Permanent)，丢弃或者放入死信
+3. 其他错误可以重试，投递次数用完时放入死信，否则按重试策略等待后重新变为 Ready
+
+等待重试的item仍然是处理中的状态，队列关闭后也会重新加入，阻塞的 Get 和 ShutDownWithDrain 会等它重试完
+*/
+func (q *TiQueue) DoneWithResult(item Itemer, result error) (err error) {
+	q.Lock()
+	defer q.Unlock()
+	defer func() { err = q.opError("done", item.GetID(), err) }()
+
+	status, ok := q.ItemStatus[item.GetID()]
+	if !ok {
+		return nil
+	}
+	if status == Ready {
+		return ErrItemNotGet
+	}
+
+	p := q.active(item.GetID())
+	if p == nil {
+		return nil
+	}
+
+	switch {
+	case result == nil:
+		q.forget(item)
+		return q.finish(item, p)
+
+	case IsPermanent(result) && q.dropPermanent:
+		q.forget(item)
+		return q.finish(item, p)
+
+	case IsPermanent(result) || q.exhausted(item):
+		//先放入死信，需要记录投递次数
+		err = q.deadLetter(p, result)
+		q.forget(item)
+		return err
+	}
+
+	var d time.Duration
+	if q.retryPolicy != nil {
+		d = q.retryPolicy.When(item)
+	}
+	if d <= 0 {
+		q.requeueInflight(p)
+		return nil
+	}
+
+	//租约不再有效，等待结束后重新变为 Ready
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	p.retrying = true
+	p.expiry = time.Time{}
+	p.timer = time.AfterFunc(d, func() {
+		q.retry(p)
+	})
+	return nil
+}
+
+//retry 等待重试结束，item 重新变为 Ready
+func (q *TiQueue) retry(p *inflight) {
+	q.Lock()
+	defer q.Unlock()
+
+	//等待期间被 Done 了
+	if !q.holding(p) {
+		return
+	}
+	q.requeueInflight(p)
+}
+
+//retryPending 是否有等待重试的item，调用者需要持有锁
+func (q *TiQueue) retryPending() bool {
+	for _, ps := range q.processing {
+		for _, p := range ps {
+			if p.retrying {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//active 最早的一个还属于消费者的处理中记录，都在等待重试时返回nil，调用者需要持有锁
+func (q *TiQueue) active(id string) *inflight {
+	for _, p := range q.processing[id] {
+		if !p.retrying {
+			return p
+		}
+	}
+	return nil
+}
+
+//forget 清除item的投递次数和重试记录，调用者需要持有锁
+func (q *TiQueue) forget(item Itemer) {
+	delete(q.deliveries, item.GetID())
+	if q.retryPolicy != nil {
+		q.retryPolicy.Forget(item)
+	}
+}
@@ -0,0 +1,200 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-20 17:02:19
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 09:35:12
+ * @FilePath: \tidb\two\retry_test.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+//TestDoneWithResultSuccess 处理成功后清除投递次数和重试记录
+func TestDoneWithResultSuccess(t *testing.T) {
+
+	q := NewTiQueue(4)
+	limiter := NewItemFixedDelayRateLimiter(0)
+	q.SetRetryPolicy(limiter)
+	q.Add(StringItem("one"))
+
+	item, _, _ := q.Get(false)
+	if err := q.DoneWithResult(item, errors.New("fail")); err != nil {
+		t.Fatal(err)
+	}
+	if limiter.NumRequeues(item) != 1 {
+		t.Errorf("requeues %v not equal 1", limiter.NumRequeues(item))
+	}
+
+	item, _, _ = q.Get(false)
+	if err := q.DoneWithResult(item, nil); err != nil {
+		t.Fatal(err)
+	}
+	if status := q.GetItemStatus(item); status != NotExist {
+		t.Errorf("status %v not equal NotExist", status)
+	}
+	if limiter.NumRequeues(item) != 0 || q.NumDeliveries(item) != 0 {
+		t.Errorf("requeues %v deliveries %v", limiter.NumRequeues(item), q.NumDeliveries(item))
+	}
+
+	//没有 Get 的item
+	q.Add(StringItem("two"))
+	if err := q.DoneWithResult(StringItem("two"), nil); !errors.Is(err, ErrItemNotGet) {
+		t.Errorf("err %v not %v", err, ErrItemNotGet)
+	}
+}
+
+//TestDoneWithResultDelay 按重试策略等待后重新变为 Ready
+func TestDoneWithResultDelay(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.SetRetryPolicy(NewItemExponentialFailureRateLimiter(20*time.Millisecond, time.Second))
+	q.Add(StringItem("one"))
+
+	item, _, _ := q.Get(false)
+	start := time.Now()
+	q.DoneWithResult(item, errors.New("fail"))
+
+	//等待期间仍然是处理中，不能再 Get 到
+	if status := q.GetItemStatus(item); status != InProcess {
+		t.Errorf("status %v not equal InProcess", status)
+	}
+	if _, _, err := q.Get(false); !errors.Is(err, ErrEmpty) {
+		t.Errorf("err %v not %v", err, ErrEmpty)
+	}
+
+	item, _, err := q.Get(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("retry after %v less than 20ms", d)
+	}
+
+	//第二次等待时间翻倍
+	start = time.Now()
+	q.DoneWithResult(item, errors.New("fail"))
+	q.Get(true)
+	if d := time.Since(start); d < 40*time.Millisecond {
+		t.Errorf("retry after %v less than 40ms", d)
+	}
+}
+
+//TestDoneWithResultPermanent 永久错误不重试
+func TestDoneWithResultPermanent(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.Add(StringItem("one"))
+	q.Add(StringItem("two"))
+
+	errBad := errors.New("bad input")
+	item, _, _ := q.Get(false)
+	err := fmt.Errorf("handle %v: %w", item.GetID(), Permanent(errBad))
+	if err := q.DoneWithResult(item, err); err != nil {
+		t.Fatal(err)
+	}
+	dl, ok := q.DeadLetters().Get("one")
+	if !ok {
+		t.Fatal("one not dead lettered")
+	}
+	if !IsPermanent(dl.LastErr) || !errors.Is(dl.LastErr, errBad) {
+		t.Errorf("last err %v", dl.LastErr)
+	}
+
+	q.SetDropPermanent(true)
+	item, _, _ = q.Get(false)
+	q.DoneWithResult(item, Permanent(errBad))
+	if _, ok := q.DeadLetters().Get("two"); ok {
+		t.Error("two dead lettered")
+	}
+	if status := q.GetItemStatus(item); status != NotExist {
+		t.Errorf("status %v not equal NotExist", status)
+	}
+}
+
+//TestDoneWithResultExhausted 投递次数用完时放入死信
+func TestDoneWithResultExhausted(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.SetMaxDeliveries(2)
+	q.Add(StringItem("one"))
+
+	for i := 0; i < 2; i++ {
+		item, _, _ := q.Get(false)
+		q.DoneWithResult(item, errors.New("fail"))
+	}
+	if dl, ok := q.DeadLetters().Get("one"); !ok || dl.Attempts != 2 {
+		t.Errorf("dead letter %+v %v", dl, ok)
+	}
+}
+
+//TestDoneWithResultDrain 关闭后等待重试的item也会被处理完
+func TestDoneWithResultDrain(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.SetRetryPolicy(NewItemFixedDelayRateLimiter(20 * time.Millisecond))
+	q.Add(StringItem("one"))
+
+	item, _, _ := q.Get(false)
+	q.DoneWithResult(item, errors.New("fail"))
+
+	go func() {
+		item, _, err := q.Get(true)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		q.DoneWithResult(item, nil)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ids, err := q.ShutDownWithDrain(ctx); err != nil {
+		t.Errorf("unfinished %v err %v", ids, err)
+	}
+}
+
+//TestDoneWhileRetrying 等待重试时调用 Done 不影响重试，重试后处理完成可以正常关闭
+func TestDoneWhileRetrying(t *testing.T) {
+
+	q := NewTiQueue(4)
+	q.SetRetryPolicy(NewItemFixedDelayRateLimiter(20 * time.Millisecond))
+	q.Add(StringItem("one"))
+
+	item, _, _ := q.Get(false)
+	q.DoneWithResult(item, errors.New("fail"))
+	if err := q.Done(item); err != nil {
+		t.Error(err)
+	}
+	if status := q.GetItemStatus(item); status != InProcess {
+		t.Errorf("status %04b", status)
+	}
+	if errs := q.CheckInvariants(); len(errs) != 0 {
+		t.Errorf("invariants %v", errs)
+	}
+
+	go func() {
+		item, _, err := q.Get(true)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		q.Done(item)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if ids, err := q.ShutDownWithDrain(ctx); err != nil {
+		t.Errorf("unfinished %v err %v", ids, err)
+	}
+	if len(q.ItemStatus) != 0 {
+		t.Errorf("status %v", q.ItemStatus)
+	}
+}
@@ -0,0 +1,134 @@
+/*
+ * @Description:ring buffer
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 20:52:10
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-23 09:52:30
+ * @FilePath: \tidb\two\ring_buffer.go
+ */
+package two
+
+import "time"
+
+//entry 队列中 Ready 状态的item
+type entry struct {
+	item    Itemer
+	addedAt time.Time //加入队列的时间
+
+	prio  int    //优先级，只有优先级队列使用
+	index int    //在堆中的下标
+	seq   uint64 //加入堆的序号
+}
+
+//minRingSize 环形队列最小的底层数组长度，缩容不会小于这个值
+const minRingSize = 16
+
+//ringBuffer 可以自动扩容和缩容的环形队列，不是并发安全的，由TiQueue的锁保护
+type ringBuffer struct {
+	buf  []*entry
+	head int //队头所在的下标
+	size int //item个数
+}
+
+func newRingBuffer() *ringBuffer {
+	return &ringBuffer{
+		buf: make([]*entry, minRingSize),
+	}
+}
+
+func (r *ringBuffer) len() int {
+	return r.size
+}
+
+//push 加入队尾，满了就扩容为两倍
+func (r *ringBuffer) push(e *entry) {
+
+	if r.size == len(r.buf) {
+		r.resize(len(r.buf) * 2)
+	}
+
+	r.buf[(r.head+r.size)%len(r.buf)] = e
+	r.size++
+}
+
+//pushFront 加入队头，满了就扩容为两倍
+func (r *ringBuffer) pushFront(e *entry) {
+
+	if r.size == len(r.buf) {
+		r.resize(len(r.buf) * 2)
+	}
+
+	r.head = (r.head - 1 + len(r.buf)) % len(r.buf)
+	r.buf[r.head] = e
+	r.size++
+}
+
+//pop 取出队头，元素个数不到四分之一时缩容为一半
+func (r *ringBuffer) pop() (*entry, bool) {
+
+	if r.size == 0 {
+		return nil, false
+	}
+
+	e := r.buf[r.head]
+	r.buf[r.head] = nil //避免内存泄漏
+	r.head = (r.head + 1) % len(r.buf)
+	r.size--
+
+	if len(r.buf) > minRingSize && r.size <= len(r.buf)/4 {
+		r.resize(len(r.buf) / 2)
+	}
+
+	return e, true
+}
+
+//remove 删除队列中间的元素，后面的元素依次前移，返回是否找到
+func (r *ringBuffer) remove(e *entry) bool {
+
+	i := 0
+	for ; i < r.size && r.buf[(r.head+i)%len(r.buf)] != e; i++ {
+	}
+	if i == r.size {
+		return false
+	}
+
+	for ; i < r.size-1; i++ {
+		r.buf[(r.head+i)%len(r.buf)] = r.buf[(r.head+i+1)%len(r.buf)]
+	}
+	r.buf[(r.head+r.size-1)%len(r.buf)] = nil //避免内存泄漏
+	r.size--
+
+	if len(r.buf) > minRingSize && r.size <= len(r.buf)/4 {
+		r.resize(len(r.buf) / 2)
+	}
+	return true
+}
+
+//peek 查看队头，不取出
+func (r *ringBuffer) peek() (*entry, bool) {
+	if r.size == 0 {
+		return nil, false
+	}
+	return r.buf[r.head], true
+}
+
+//each 从队头开始依次遍历，fn 返回 false 时停止
+func (r *ringBuffer) each(fn func(e *entry) bool) {
+	for i := 0; i < r.size; i++ {
+		if !fn(r.buf[(r.head+i)%len(r.buf)]) {
+			return
+		}
+	}
+}
+
+//resize 按顺序拷贝到新的数组，队头从0开始
+func (r *ringBuffer) resize(n int) {
+
+	buf := make([]*entry, n)
+	for i := 0; i < r.size; i++ {
+		buf[i] = r.buf[(r.head+i)%len(r.buf)]
+	}
+	r.buf = buf
+	r.head = 0
+}
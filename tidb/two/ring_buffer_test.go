@@ -0,0 +1,109 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-18 21:33:02
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-22 11:30:41
+ * @FilePath: \tidb\two\ring_buffer_test.go
+ */
+package two
+
+import "testing"
+
+//TestRingBuffer 扩容缩容后仍然保持先进先出
+func TestRingBuffer(t *testing.T) {
+
+	r := newRingBuffer()
+
+	//先让队头移动到中间，扩容时需要处理绕回的情况
+	for v := 0; v < 10; v++ {
+		r.push(&entry{item: IntItem(v)})
+	}
+	for v := 0; v < 10; v++ {
+		e, ok := r.pop()
+		if !ok || e.item != IntItem(v) {
+			t.Errorf("pop %v %v not equal %v", e, ok, v)
+		}
+	}
+
+	for v := 0; v < 1000; v++ {
+		r.push(&entry{item: IntItem(v)})
+	}
+	if r.len() != 1000 {
+		t.Errorf("len %v not equal %v", r.len(), 1000)
+	}
+	if len(r.buf) != 1024 {
+		t.Errorf("buf %v not equal %v", len(r.buf), 1024)
+	}
+
+	for v := 0; v < 1000; v++ {
+		e, ok := r.pop()
+		if !ok || e.item != IntItem(v) {
+			t.Errorf("pop %v %v not equal %v", e, ok, v)
+		}
+	}
+
+	//全部取出后缩容到最小值
+	if len(r.buf) != minRingSize {
+		t.Errorf("buf %v not equal %v", len(r.buf), minRingSize)
+	}
+
+	_, ok := r.pop()
+	if ok {
+		t.Errorf("pop empty %v not equal %v", ok, false)
+	}
+
+}
+
+func TestRingBufferPushFront(t *testing.T) {
+
+	r := newRingBuffer()
+	for v := 0; v < 20; v++ {
+		r.push(&entry{item: IntItem(v)})
+	}
+	for v := -1; v >= -20; v-- {
+		r.pushFront(&entry{item: IntItem(v)})
+	}
+	for v := -20; v < 20; v++ {
+		e, ok := r.pop()
+		if !ok || e.item != IntItem(v) {
+			t.Errorf("pop %v %v not equal %v", e, ok, v)
+		}
+	}
+}
+
+func TestRingBufferRemove(t *testing.T) {
+
+	r := newRingBuffer()
+	var entries []*entry
+	for v := 0; v < 40; v++ {
+		e := &entry{item: IntItem(v)}
+		entries = append(entries, e)
+		r.push(e)
+	}
+	//队头移动到中间
+	for v := 0; v < 10; v++ {
+		r.pop()
+	}
+	for v := 10; v < 40; v += 3 {
+		if !r.remove(entries[v]) {
+			t.Errorf("remove %v not found", v)
+		}
+	}
+	if r.remove(entries[0]) {
+		t.Error("remove popped entry")
+	}
+	for v := 10; v < 40; v++ {
+		if (v-10)%3 == 0 {
+			continue
+		}
+		e, ok := r.pop()
+		if !ok || e.item != IntItem(v) {
+			t.Errorf("pop %v %v not equal %v", e, ok, v)
+		}
+	}
+	if r.len() != 0 {
+		t.Errorf("len %v not equal 0", r.len())
+	}
+}
@@ -0,0 +1,282 @@
+/*
+ * @Description:runner
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-24 14:05:11
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 15:32:09
+ * @FilePath: \tidb\two\runner.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+var ErrRunnerRunning = errors.New("runner is running") //同一个 Runner 同时只能 Run 一次
+
+//Get 持续出错(比如日志已经关闭)时 worker 等待的时间，每次翻倍，取到item后重置
+const (
+	runnerMinBackoff = 10 * time.Millisecond
+	runnerMaxBackoff = time.Second
+)
+
+//Handler 处理一个item，返回nil表示处理成功，返回错误时按 TiQueue.DoneWithResult 的规则重试或者放入死信
+type Handler func(ctx context.Context, item Itemer) error
+
+//PanicError Handler panic 时转换成的错误，和其他错误一样会重试
+type PanicError struct {
+	Value interface{} //recover 得到的值
+	Stack []byte      //panic 时的调用栈
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("handler panic: %v", e.Value)
+}
+
+/*
+Runner 在 TiQueue 上运行一组 worker，每个 worker 循环 Get -> Handler -> DoneWithResult。
+
+1. Handler panic 时恢复并转换成 *PanicError，当作处理失败
+2. SetTimeout 设置每个item的处理时间，超时后 Handler 的 ctx 会结束，Handler 需要响应 ctx
+3. 处理失败的item通过 DoneWithResult 重新加入队列，等待时间和最大投递次数由队列的 SetRetryPolicy、SetMaxDeliveries 决定，
+   队列没有设置重试策略时 NewRunner 设置为 DefaultRateLimiter，避免一直失败的item被立即重试，占满 worker
+4. 队列 ShutDown 后 worker 处理完剩下的item再退出，Run 在所有 worker 退出后返回
+5. SetWorkers 可以在运行中调整 worker 个数，减少的 worker 处理完手上的item再退出
+6. Get 返回状态不一致以外的错误时 worker 退避后再试，避免空转
+*/
+type Runner struct {
+	queue   *TiQueue
+	handler Handler
+
+	mu      sync.Mutex
+	workers int                  //期望的 worker 个数
+	stops   []context.CancelFunc //运行中的 worker，调用后 worker 处理完手上的item退出
+	live    int                  //还没有退出的 worker 个数，包括已经要求退出的
+	idle    chan struct{}        //live 变为0时关闭，用于 Run 等待所有 worker 退出
+	ctx     context.Context      //Run 的ctx，nil 表示没有运行
+	timeout time.Duration        //每个item的处理时间，0表示不限制
+	onError func(item Itemer, err error)
+}
+
+//NewRunner 新建 Runner，workers 为 worker 的个数，调用 Run 后开始处理
+func NewRunner(q *TiQueue, handler Handler, workers int) *Runner {
+
+	q.Lock()
+	if q.retryPolicy == nil {
+		q.retryPolicy = DefaultRateLimiter()
+	}
+	q.Unlock()
+
+	return &Runner{
+		queue:   q,
+		handler: handler,
+		workers: workers,
+	}
+}
+
+//SetTimeout 设置每个item的处理时间，0表示不限制
+func (r *Runner) SetTimeout(d time.Duration) {
+	r.mu.Lock()
+	r.timeout = d
+	r.mu.Unlock()
+}
+
+//SetErrorHandler 设置处理失败(包括 panic)和 DoneWithResult 失败时的回调，用于记录日志，不能阻塞
+func (r *Runner) SetErrorHandler(fn func(item Itemer, err error)) {
+	r.mu.Lock()
+	r.onError = fn
+	r.mu.Unlock()
+}
+
+//SetWorkers 调整 worker 的个数，运行中时立即生效
+func (r *Runner) SetWorkers(n int) {
+	if n < 0 {
+		n = 0
+	}
+	r.mu.Lock()
+	r.workers = n
+	if r.ctx != nil {
+		r.scale()
+	}
+	r.mu.Unlock()
+}
+
+//Workers 期望的 worker 个数，减少后还在处理item的 worker 不算在内
+func (r *Runner) Workers() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.workers
+}
+
+/*
+Run 启动 worker 并阻塞，直到队列关闭并且 worker 都退出了，或者 ctx 结束。
+ctx 结束时正在处理的item的 ctx 也会结束，Handler 返回后 Run 才返回；
+worker 个数为0时队列关闭后直接返回，剩下的item留在队列中
+*/
+func (r *Runner) Run(ctx context.Context) error {
+
+	r.mu.Lock()
+	if r.ctx != nil {
+		r.mu.Unlock()
+		return ErrRunnerRunning
+	}
+	r.ctx = ctx
+	r.scale()
+	r.mu.Unlock()
+
+	select {
+	case <-r.queue.GetCloseNotify():
+	case <-ctx.Done():
+	}
+
+	r.mu.Lock()
+	for r.live > 0 {
+		if r.idle == nil {
+			r.idle = make(chan struct{}, 0)
+		}
+		idle := r.idle
+		r.mu.Unlock()
+		<-idle
+		r.mu.Lock()
+	}
+	for _, stop := range r.stops {
+		stop()
+	}
+	r.ctx = nil
+	r.stops = nil
+	r.mu.Unlock()
+	return ctx.Err()
+}
+
+//scale 按期望的个数启动或者停止 worker，调用者需要持有锁
+func (r *Runner) scale() {
+
+	for len(r.stops) < r.workers {
+		stop, cancel := context.WithCancel(r.ctx)
+		r.stops = append(r.stops, cancel)
+		r.live++
+		go r.work(r.ctx, stop)
+	}
+	for len(r.stops) > r.workers {
+		last := len(r.stops) - 1
+		r.stops[last]()
+		r.stops = r.stops[:last]
+	}
+}
+
+//work worker 的循环，stop 结束后不再获取新的item，处理中的item使用 ctx
+func (r *Runner) work(ctx context.Context, stop context.Context) {
+
+	defer r.exit()
+
+	backoff := time.Duration(0)
+	for {
+		//队列中有item时 GetContext 不会检查 stop，需要先检查
+		if stop.Err() != nil {
+			return
+		}
+		item, shutdown, err := r.queue.GetContext(stop)
+		if shutdown {
+			return
+		}
+		if err != nil {
+			if stop.Err() != nil {
+				return
+			}
+			r.report(nil, err)
+
+			//状态不一致的item已经被丢弃了，继续取下一个
+			var se *StatusError
+			if errors.As(err, &se) {
+				continue
+			}
+
+			//其他错误(比如 ErrWALClosed)再取多半还是失败，等一会再试
+			if backoff *= 2; backoff == 0 {
+				backoff = runnerMinBackoff
+			} else if backoff > runnerMaxBackoff {
+				backoff = runnerMaxBackoff
+			}
+			timer := time.NewTimer(backoff)
+			select {
+			case <-timer.C:
+			case <-stop.Done():
+				timer.Stop()
+				return
+			}
+			continue
+		}
+		backoff = 0
+
+		result := r.process(ctx, item)
+		if ctx.Err() != nil {
+			//Run 结束导致的失败不算一次投递，立即重新加入队列，不等待重试
+			r.release(item)
+			continue
+		}
+		if result != nil {
+			r.report(item, result)
+		}
+		if err := r.queue.DoneWithResult(item, result); err != nil {
+			r.report(item, err)
+		}
+	}
+}
+
+//release 处理中的item重新变为 Ready，不计入投递次数
+func (r *Runner) release(item Itemer) {
+
+	q := r.queue
+	q.Lock()
+	defer q.Unlock()
+	if p := q.active(item.GetID()); p != nil {
+		q.releaseInflight(p)
+	}
+}
+
+//process 调用 Handler，设置处理时间并且恢复 panic
+func (r *Runner) process(ctx context.Context, item Itemer) (err error) {
+
+	r.mu.Lock()
+	timeout := r.timeout
+	r.mu.Unlock()
+
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	defer func() {
+		if v := recover(); v != nil {
+			err = &PanicError{Value: v, Stack: debug.Stack()}
+		}
+	}()
+	return r.handler(ctx, item)
+}
+
+func (r *Runner) report(item Itemer, err error) {
+	r.mu.Lock()
+	onError := r.onError
+	r.mu.Unlock()
+	if onError != nil {
+		onError(item, err)
+	}
+}
+
+//exit worker 退出，最后一个退出时唤醒等待的 Run
+func (r *Runner) exit() {
+	r.mu.Lock()
+	r.live--
+	if r.live == 0 && r.idle != nil {
+		close(r.idle)
+		r.idle = nil
+	}
+	r.mu.Unlock()
+}
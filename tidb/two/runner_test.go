@@ -0,0 +1,290 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-24 15:52:40
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 15:32:09
+ * @FilePath: \tidb\two\runner_test.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+//TestRunnerShutDown 队列关闭后处理完剩下的item，Run 返回
+func TestRunnerShutDown(t *testing.T) {
+
+	q := NewTiQueue(0)
+	var mu sync.Mutex
+	seen := make(map[string]int)
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		mu.Lock()
+		seen[item.GetID()]++
+		mu.Unlock()
+		return nil
+	}, 4)
+
+	for i := 0; i < 100; i++ {
+		q.Add(StringItem(strconv.Itoa(i)))
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background())
+	}()
+	q.ShutDown()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("run not return")
+	}
+	if len(seen) != 100 {
+		t.Errorf("seen %v not equal 100", len(seen))
+	}
+	for id, n := range seen {
+		if n != 1 {
+			t.Errorf("%v handled %v times", id, n)
+		}
+	}
+	if q.Len() != 0 || len(q.ItemStatus) != 0 {
+		t.Errorf("len %v status %v", q.Len(), q.ItemStatus)
+	}
+}
+
+//TestRunnerRetryPanic panic 和失败的item重新加入队列，次数用完后放入死信
+func TestRunnerRetryPanic(t *testing.T) {
+
+	q := NewTiQueue(0)
+	q.SetMaxDeliveries(3)
+	var calls int32
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		n := atomic.AddInt32(&calls, 1)
+		switch item.GetID() {
+		case "panic":
+			panic("boom")
+		case "flaky":
+			if n < 3 {
+				return errors.New("fail")
+			}
+		}
+		return nil
+	}, 1)
+
+	var mu sync.Mutex
+	var panics int
+	r.SetErrorHandler(func(item Itemer, err error) {
+		var pe *PanicError
+		if errors.As(err, &pe) {
+			mu.Lock()
+			panics++
+			mu.Unlock()
+			if pe.Value != "boom" || len(pe.Stack) == 0 {
+				t.Errorf("panic %+v", pe)
+			}
+		}
+	})
+
+	q.Add(StringItem("flaky"))
+	q.Add(StringItem("panic"))
+	q.ShutDown()
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if panics != 3 {
+		t.Errorf("panics %v not equal 3", panics)
+	}
+	if _, ok := q.DeadLetters().Get("panic"); !ok {
+		t.Error("panic not dead lettered")
+	}
+	if _, ok := q.DeadLetters().Get("flaky"); ok {
+		t.Error("flaky dead lettered")
+	}
+}
+
+//TestRunnerTimeout 超时后 Handler 的 ctx 结束，item 重试
+func TestRunnerTimeout(t *testing.T) {
+
+	q := NewTiQueue(0)
+	var calls int32
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			return nil
+		}
+		<-ctx.Done()
+		return ctx.Err()
+	}, 1)
+	r.SetTimeout(20 * time.Millisecond)
+
+	q.Add(StringItem("one"))
+	q.ShutDown()
+	start := time.Now()
+	if err := r.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 2 {
+		t.Errorf("calls %v not equal 2", calls)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Errorf("run %v before timeout", d)
+	}
+}
+
+//TestRunnerSetWorkers 运行中调整 worker 个数
+func TestRunnerSetWorkers(t *testing.T) {
+
+	q := NewTiQueue(0)
+	var running, peak int32
+	release := make(chan struct{})
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		return nil
+	}, 1)
+
+	for i := 0; i < 20; i++ {
+		q.Add(StringItem(strconv.Itoa(i)))
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(context.Background())
+	}()
+
+	waitRunning := func(want int32) {
+		deadline := time.Now().Add(time.Second)
+		for atomic.LoadInt32(&running) != want {
+			if time.Now().After(deadline) {
+				t.Fatalf("running %v not equal %v", atomic.LoadInt32(&running), want)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitRunning(1)
+	r.SetWorkers(4)
+	waitRunning(4)
+	if r.Workers() != 4 {
+		t.Errorf("workers %v not equal 4", r.Workers())
+	}
+
+	//减少到0后处理中的item仍然完成，不再取新的
+	r.SetWorkers(0)
+	close(release)
+	waitRunning(0)
+	time.Sleep(20 * time.Millisecond)
+	if n := q.Len(); n != 16 {
+		t.Errorf("len %v not equal 16", n)
+	}
+
+	r.SetWorkers(2)
+	q.ShutDown()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if q.Len() != 0 || peak != 4 {
+		t.Errorf("len %v peak %v", q.Len(), peak)
+	}
+}
+
+//TestRunnerContext ctx 结束后 Run 返回，处理中的item重新加入队列
+func TestRunnerContext(t *testing.T) {
+
+	q := NewTiQueue(0)
+	started := make(chan struct{})
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		close(started)
+		<-ctx.Done()
+		return ctx.Err()
+	}, 2)
+
+	q.Add(StringItem("one"))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(ctx)
+	}()
+	<-started
+
+	if err := r.Run(ctx); err != ErrRunnerRunning {
+		t.Errorf("err %v not %v", err, ErrRunnerRunning)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("err %v not %v", err, context.Canceled)
+	}
+	if status := q.GetItemStatus(StringItem("one")); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+}
+
+//TestRunnerBackoff 日志关闭后 Get 一直失败，worker 退避而不是空转
+func TestRunnerBackoff(t *testing.T) {
+
+	q, err := NewDurableTiQueue(0, filepath.Join(t.TempDir(), "queue.wal"), JSONCodec[Task]{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	q.Add(Task{ID: "one"})
+	q.Close()
+
+	var errs int32
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		return nil
+	}, 2)
+	r.SetErrorHandler(func(item Itemer, err error) {
+		if !errors.Is(err, ErrWALClosed) {
+			t.Errorf("err %v not %v", err, ErrWALClosed)
+		}
+		atomic.AddInt32(&errs, 1)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if err := r.Run(ctx); err != context.DeadlineExceeded {
+		t.Errorf("err %v not %v", err, context.DeadlineExceeded)
+	}
+	//每个 worker 在 100ms 内只在 0、10、30、70ms 时取一次，空转时会有成千上万次
+	if n := atomic.LoadInt32(&errs); n == 0 || n > 12 {
+		t.Errorf("errors %v", n)
+	}
+}
+
+//TestRunnerPoisonItem 没有设置重试策略时一直失败的item也会退避，不会空转
+func TestRunnerPoisonItem(t *testing.T) {
+
+	q := NewTiQueue(0)
+	var calls int32
+	r := NewRunner(q, func(ctx context.Context, item Itemer) error {
+		atomic.AddInt32(&calls, 1)
+		return errors.New("fail")
+	}, 1)
+	q.Add(StringItem("poison"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r.Run(ctx)
+	//从5ms开始指数退避，100ms 内只会处理几次
+	if n := atomic.LoadInt32(&calls); n == 0 || n > 10 {
+		t.Errorf("calls %v", n)
+	}
+}
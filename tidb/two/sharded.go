@@ -0,0 +1,201 @@
+/*
+ * @Description:sharded queue
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-21 14:05:37
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-24 11:34:04
+ * @FilePath: \tidb\two\sharded.go
+ */
+package two
+
+import (
+	"context"
+	"errors"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+)
+
+var _ Queue = (*ShardedTiQueue)(nil)
+
+/*
+ShardedTiQueue 把item按 GetID() 的哈希分散到多个 TiQueue 中，每个分片有自己的锁和状态，
+多核下多个生产者和消费者不会都竞争同一把锁。相同的item总是在同一个分片，去重和状态与 TiQueue 相同，
+但是不同分片之间不保证按加入的顺序取出。
+
+Get 从轮转的起始分片开始依次尝试取出，都为空时在共享的 notEmpty 上等待。
+只有存在等待者时 Add 才需要获取共享的锁去唤醒，没有等待者时 Add 只竞争分片的锁。
+*/
+type ShardedTiQueue struct {
+	shards []*TiQueue
+	next   uint32 //下一次 Get 的起始分片
+	done   chan struct{}
+	once   sync.Once
+
+	mu       sync.Mutex
+	notEmpty chan struct{} //有阻塞的 Get 在等待时才创建，Add 后关闭它来唤醒等待者
+	waiters  int32         //阻塞等待的 Get 个数
+}
+
+//NewShardedTiQueue 创建 n 个分片的队列，maxCap 为总容量，平均分到每个分片，小于等于0时不限制容量
+func NewShardedTiQueue(n, maxCap int) *ShardedTiQueue {
+
+	if n <= 0 {
+		n = 1
+	}
+	shardCap := 0
+	if maxCap > 0 {
+		shardCap = (maxCap + n - 1) / n
+	}
+
+	s := &ShardedTiQueue{
+		shards: make([]*TiQueue, n),
+		done:   make(chan struct{}, 0),
+	}
+	for i := range s.shards {
+		s.shards[i] = NewTiQueue(shardCap)
+	}
+	return s
+}
+
+//shard item 所在的分片
+func (s *ShardedTiQueue) shard(item Itemer) *TiQueue {
+	h := fnv.New32a()
+	h.Write([]byte(item.GetID()))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+//Add 添加item到所在的分片，分片满了时返回错误
+func (s *ShardedTiQueue) Add(item Itemer) error {
+
+	if err := s.shard(item).Add(item); err != nil {
+		return err
+	}
+
+	//有等待者时才去唤醒
+	if atomic.LoadInt32(&s.waiters) > 0 {
+		s.mu.Lock()
+		if s.notEmpty != nil {
+			close(s.notEmpty)
+			s.notEmpty = nil
+		}
+		s.mu.Unlock()
+	}
+	return nil
+}
+
+//Get 从所有分片中获取item，block 标记是否阻塞读，所有分片都关闭并且没有item时 shutdown 为 true
+func (s *ShardedTiQueue) Get(block bool) (item Itemer, shutdown bool, err error) {
+	return s.get(context.Background(), block)
+}
+
+//GetContext 阻塞读，直到获取到item、队列关闭并且没有item或者ctx结束
+func (s *ShardedTiQueue) GetContext(ctx context.Context) (item Itemer, shutdown bool, err error) {
+	return s.get(ctx, true)
+}
+
+func (s *ShardedTiQueue) get(ctx context.Context, block bool) (Itemer, bool, error) {
+
+	for {
+		//有item时不需要碰共享的锁
+		item, shutdown, err := s.tryGet()
+		if item != nil || shutdown || err != nil {
+			return item, shutdown, err
+		}
+		if !block {
+			return nil, false, &QueueError{Op: "get", Err: ErrEmpty}
+		}
+
+		//先登记为等待者并拿到等待的channel，再检查一次分片，之后的 Add 一定会关闭这个channel
+		atomic.AddInt32(&s.waiters, 1)
+		s.mu.Lock()
+		if s.notEmpty == nil {
+			s.notEmpty = make(chan struct{}, 0)
+		}
+		notEmpty := s.notEmpty
+		s.mu.Unlock()
+
+		item, shutdown, err = s.tryGet()
+		if item != nil || shutdown || err != nil {
+			atomic.AddInt32(&s.waiters, -1)
+			return item, shutdown, err
+		}
+
+		select {
+		case <-notEmpty:
+		case <-s.done:
+			//关闭后所有分片都会返回 shutdown 或者item，不会空转
+		case <-ctx.Done():
+			atomic.AddInt32(&s.waiters, -1)
+			return nil, false, ctx.Err()
+		}
+		atomic.AddInt32(&s.waiters, -1)
+	}
+}
+
+//tryGet 从轮转的起始分片开始依次非阻塞的获取，所有分片都返回 shutdown 时才算关闭
+func (s *ShardedTiQueue) tryGet() (Itemer, bool, error) {
+
+	n := len(s.shards)
+	start := int(atomic.AddUint32(&s.next, 1) % uint32(n))
+	shutdowns := 0
+	for i := 0; i < n; i++ {
+		item, shutdown, err := s.shards[(start+i)%n].Get(false)
+		if item != nil {
+			return item, false, nil
+		}
+		if shutdown {
+			shutdowns++
+			continue
+		}
+		if !errors.Is(err, ErrEmpty) {
+			return nil, false, err
+		}
+	}
+	return nil, shutdowns == n, nil
+}
+
+//Len 所有分片中 Ready 状态的item个数
+func (s *ShardedTiQueue) Len() int {
+	n := 0
+	for _, q := range s.shards {
+		n += q.Len()
+	}
+	return n
+}
+
+//Done 表示item处理完成了
+func (s *ShardedTiQueue) Done(item Itemer) error {
+	return s.shard(item).Done(item)
+}
+
+//ShutDown 关闭所有分片
+func (s *ShardedTiQueue) ShutDown() {
+	for _, q := range s.shards {
+		q.ShutDown()
+	}
+	s.once.Do(func() {
+		close(s.done)
+	})
+}
+
+//ShuttingDown 已经关闭并且还有分片没有处理完
+func (s *ShardedTiQueue) ShuttingDown() bool {
+	for _, q := range s.shards {
+		if q.ShuttingDown() {
+			return true
+		}
+	}
+	return false
+}
+
+//GetCloseNotify 获取关闭通知
+func (s *ShardedTiQueue) GetCloseNotify() <-chan struct{} {
+	return s.done
+}
+
+//GetItemStatus 获取item状态
+func (s *ShardedTiQueue) GetItemStatus(item Itemer) ItemStatus {
+	return s.shard(item).GetItemStatus(item)
+}
@@ -0,0 +1,210 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-21 15:35:12
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-27 15:33:47
+ * @FilePath: \tidb\two\sharded_test.go
+ */
+package two
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedStatus(t *testing.T) {
+
+	q := NewShardedTiQueue(4, 0)
+	for i := 0; i < 100; i++ {
+		if err := q.Add(IntItem(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := q.Add(IntItem(7)); !errors.Is(err, ErrItemExist) {
+		t.Errorf("err %v not %v", err, ErrItemExist)
+	}
+	if q.Len() != 100 {
+		t.Errorf("len %v not equal 100", q.Len())
+	}
+
+	seen := make(map[string]bool, 0)
+	for i := 0; i < 100; i++ {
+		item, _, err := q.Get(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen[item.GetID()] {
+			t.Errorf("%v get twice", item.GetID())
+		}
+		seen[item.GetID()] = true
+		if status := q.GetItemStatus(item); status != InProcess {
+			t.Errorf("%v status %v not equal InProcess", item, status)
+		}
+	}
+	if _, _, err := q.Get(false); !errors.Is(err, ErrEmpty) {
+		t.Errorf("err %v not %v", err, ErrEmpty)
+	}
+
+	//处理中的item可以再加入一次
+	if err := q.Add(IntItem(7)); err != nil {
+		t.Fatal(err)
+	}
+	if status := q.GetItemStatus(IntItem(7)); status != (Ready<<2)|InProcess {
+		t.Errorf("status %b", status)
+	}
+	q.Done(IntItem(7))
+	if status := q.GetItemStatus(IntItem(7)); status != Ready {
+		t.Errorf("status %v not equal Ready", status)
+	}
+}
+
+func TestShardedCap(t *testing.T) {
+
+	q := NewShardedTiQueue(2, 2)
+	added := 0
+	for i := 0; i < 10; i++ {
+		if q.Add(IntItem(i)) == nil {
+			added++
+		}
+	}
+	//每个分片容量为1
+	if added != 2 {
+		t.Errorf("added %v not equal 2", added)
+	}
+}
+
+//TestShardedBlockingGet 阻塞的 Get 被任意分片的 Add 唤醒
+func TestShardedBlockingGet(t *testing.T) {
+
+	q := NewShardedTiQueue(8, 0)
+	got := make(chan Itemer, 0)
+	go func() {
+		item, _, _ := q.Get(true)
+		got <- item
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	q.Add(StringItem("one"))
+
+	select {
+	case item := <-got:
+		if item.GetID() != "one" {
+			t.Errorf("%v not equal one", item.GetID())
+		}
+	case <-time.After(time.Second):
+		t.Fatal("get not woken")
+	}
+}
+
+//TestShardedMultiProduceMultiConsumer 多个生产者和消费者，关闭后消费者取完所有item后退出
+func TestShardedMultiProduceMultiConsumer(t *testing.T) {
+
+	q := NewShardedTiQueue(4, 0)
+	producer := sync.WaitGroup{}
+	consumer := sync.WaitGroup{}
+	var cnt int64
+
+	for p := 0; p < 3; p++ {
+		producer.Add(1)
+		go func(p int) {
+			defer producer.Done()
+			for v := p * 1000; v < (p+1)*1000; v++ {
+				if err := q.Add(IntItem(v)); err != nil {
+					t.Error(err)
+				}
+			}
+		}(p)
+	}
+
+	for c := 0; c < 4; c++ {
+		consumer.Add(1)
+		go func() {
+			defer consumer.Done()
+			for {
+				item, shutdown, err := q.Get(true)
+				if shutdown {
+					return
+				}
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				q.Done(item)
+				atomic.AddInt64(&cnt, 1)
+			}
+		}()
+	}
+
+	producer.Wait()
+	q.ShutDown()
+	consumer.Wait()
+
+	if cnt != 3000 {
+		t.Errorf("consume %v not equal %v", cnt, 3000)
+	}
+	if q.ShuttingDown() {
+		t.Error("still shutting down")
+	}
+}
+
+//benchmarkQueue 队列中预先放入item，每个 goroutine 依次 Get、Done、再 Add 回去，统计每轮的延迟，报告吞吐和 p99
+func benchmarkQueue(b *testing.B, q Queue) {
+
+	for i := 0; i < 1024; i++ {
+		q.Add(IntItem(i))
+	}
+
+	var mu sync.Mutex
+	var latencies []time.Duration
+
+	b.ResetTimer()
+	begin := time.Now()
+	b.RunParallel(func(pb *testing.PB) {
+		local := make([]time.Duration, 0, 1024)
+		for pb.Next() {
+			start := time.Now()
+			item, _, err := q.Get(true)
+			if err != nil {
+				b.Error(err)
+				return
+			}
+			q.Done(item)
+			q.Add(item)
+			local = append(local, time.Since(start))
+		}
+		mu.Lock()
+		latencies = append(latencies, local...)
+		mu.Unlock()
+	})
+	b.StopTimer()
+	elapsed := time.Since(begin)
+
+	if len(latencies) == 0 {
+		return
+	}
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	p99 := latencies[len(latencies)*99/100]
+	b.ReportMetric(float64(p99.Nanoseconds()), "p99-ns")
+	b.ReportMetric(float64(b.N)/elapsed.Seconds(), "ops/s")
+}
+
+func BenchmarkTiQueue(b *testing.B) {
+	benchmarkQueue(b, NewUnboundedTiQueue())
+}
+
+func BenchmarkShardedTiQueue(b *testing.B) {
+	for _, n := range []int{4, 16} {
+		b.Run(strconv.Itoa(n), func(b *testing.B) {
+			benchmarkQueue(b, NewShardedTiQueue(n, 0))
+		})
+	}
+}
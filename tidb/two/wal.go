@@ -0,0 +1,395 @@
+/*
+ * @Description:write ahead log
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 16:25:51
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-28 10:12:40
+ * @FilePath: \tidb\two\wal.go
+ */
+package two
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+/*
+日志由一条条记录组成，每条记录的格式如下，数字都是小端
+
++--------+--------+----+-----------+----+------+
+| length |  crc   | op | id length | id | data |
++--------+--------+----+-----------+----+------+
+  4 byte   4 byte  1 byte  uvarint
+
+length 和 crc 都是针对后面的 body(op 到 data) 计算的，只有 add 记录有 data，也就是 codec 序列化后的item。
+进程崩溃时最后一条记录可能只写了一半，回放时遇到长度不够、长度超过 walMaxRecord 或者crc不对就认为日志到此结束。
+*/
+const (
+	walAdd    byte = 1 + iota //item 加入队列
+	walGet                    //item 被取出处理
+	walDone                   //item 处理完成
+	walRemove                 //Ready 的item被删除
+)
+
+//walMinRecords 写入的记录数超过这个值并且超过压缩后记录数的两倍时压缩日志
+const walMinRecords = 1024
+
+//walMaxRecord 一条记录 body 的最大长度，避免回放时按写坏的 length 分配过大的内存
+const walMaxRecord = 64 << 20
+
+var ErrWALClosed = errors.New("wal is closed")
+var ErrWALOp = errors.New("wal unknown op")
+var ErrWALTooLarge = errors.New("wal record too large")
+var ErrWALFailed = errors.New("wal failed") //追加失败后没能回滚，日志中间可能有写了一半的记录，压缩成功之前拒绝追加
+
+//walRecord 日志中的一条记录
+type walRecord struct {
+	op   byte
+	id   string
+	data []byte
+}
+
+//walFile 日志文件，测试时可以替换成会出错的实现
+type walFile interface {
+	io.Writer
+	io.Seeker
+	Sync() error
+	Truncate(size int64) error
+	Close() error
+}
+
+//walLog 追加写的日志文件，由TiQueue的锁保护
+type walLog struct {
+	path       string
+	codec      Codec
+	f          walFile
+	size       int64 //当前日志文件中完整记录的长度，追加失败时截断到这里
+	records    int   //当前日志文件中的记录数
+	minRecords int   //压缩的阈值
+	failed     error //追加失败并且没能回滚，之后的追加都返回这个错误
+}
+
+//openWAL 打开日志文件并读出所有完整的记录，文件不存在就创建
+func openWAL(path string, codec Codec) (*walLog, []walRecord, error) {
+
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	recs, err := readRecords(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	//最后可能有写了一半的记录，打开后会立即压缩掉
+	size, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	w := &walLog{
+		path:       path,
+		codec:      codec,
+		f:          f,
+		size:       size,
+		records:    len(recs),
+		minRecords: walMinRecords,
+	}
+	return w, recs, nil
+}
+
+func readRecords(r io.Reader) ([]walRecord, error) {
+
+	var recs []walRecord
+	header := make([]byte, 8)
+	for {
+		_, err := io.ReadFull(r, header)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		//length 写坏了，不能按它分配内存
+		length := binary.LittleEndian.Uint32(header[0:4])
+		if length > walMaxRecord {
+			return recs, nil
+		}
+		body := make([]byte, length)
+		_, err = io.ReadFull(r, body)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return recs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		//最后一条记录没有写完整
+		if crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(header[4:8]) || len(body) == 0 {
+			return recs, nil
+		}
+
+		idLen, n := binary.Uvarint(body[1:])
+		if n <= 0 || uint64(len(body)-1-n) < idLen {
+			return recs, nil
+		}
+		recs = append(recs, walRecord{
+			op:   body[0],
+			id:   string(body[1+n : 1+n+int(idLen)]),
+			data: body[1+n+int(idLen):],
+		})
+	}
+}
+
+func encodeRecord(op byte, id string, data []byte) []byte {
+
+	idLen := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(idLen, uint64(len(id)))
+
+	rec := make([]byte, 8, 8+1+n+len(id)+len(data))
+	rec = append(rec, op)
+	rec = append(rec, idLen[:n]...)
+	rec = append(rec, id...)
+	rec = append(rec, data...)
+
+	body := rec[8:]
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(len(body)))
+	binary.LittleEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(body))
+	return rec
+}
+
+//encode 生成item的记录，只有 add 需要序列化item，超过 walMaxRecord 的记录回放时读不出来，直接拒绝
+func (w *walLog) encode(op byte, item Itemer) ([]byte, error) {
+
+	var data []byte
+	if op == walAdd {
+		var err error
+		data, err = w.codec.Encode(item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	id := item.GetID()
+	if 1+binary.MaxVarintLen64+len(id)+len(data) > walMaxRecord {
+		return nil, ErrWALTooLarge
+	}
+	return encodeRecord(op, id, data), nil
+}
+
+//append 追加一条记录并落盘，没有开启持久化(w为nil)时什么都不做
+func (w *walLog) append(op byte, item Itemer) error {
+
+	if w == nil {
+		return nil
+	}
+	if w.f == nil {
+		return ErrWALClosed
+	}
+	if w.failed != nil {
+		return w.failed
+	}
+
+	rec, err := w.encode(op, item)
+	if err != nil {
+		return err
+	}
+	if _, err = w.f.Write(rec); err == nil {
+		err = w.f.Sync()
+	}
+	if err != nil {
+		w.rollback(err)
+		return err
+	}
+
+	w.size += int64(len(rec))
+	w.records++
+	return nil
+}
+
+//rollback 追加失败，截断写了一部分的记录，否则回放时会停在这里，丢掉之后追加的记录
+func (w *walLog) rollback(cause error) {
+	err := w.f.Truncate(w.size)
+	if err == nil {
+		_, err = w.f.Seek(w.size, io.SeekStart)
+	}
+	if err != nil {
+		w.failed = fmt.Errorf("%w: %v, truncate: %v", ErrWALFailed, cause, err)
+	}
+}
+
+//needCompact 日志中的记录是否远多于压缩后的记录数 snapshot
+func (w *walLog) needCompact(snapshot int) bool {
+	return w != nil && w.f != nil && w.records > w.minRecords && w.records > 2*snapshot
+}
+
+//rewrite 把 snapshot 写出的记录写到临时文件，然后原子的替换掉原来的日志
+func (w *walLog) rewrite(snapshot func(write func(op byte, item Itemer) error) error) error {
+
+	if w == nil {
+		return nil
+	}
+	if w.f == nil {
+		return ErrWALClosed
+	}
+
+	tmp := w.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	records := 0
+	size := int64(0)
+	bw := bufio.NewWriter(f)
+	err = snapshot(func(op byte, item Itemer) error {
+		rec, err := w.encode(op, item)
+		if err != nil {
+			return err
+		}
+		records++
+		size += int64(len(rec))
+		_, err = bw.Write(rec)
+		return err
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmp, w.path)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	//新的日志是从内存中的状态重新写的，之前追加失败的影响已经没有了
+	w.f.Close()
+	w.f = f
+	w.size = size
+	w.records = records
+	w.failed = nil
+
+	//rename 之后要同步所在的目录，否则掉电后目录项可能还指向原来的日志
+	return syncDir(filepath.Dir(w.path))
+}
+
+//syncDir 把目录的修改落盘
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *walLog) close() error {
+	if w == nil || w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+//replayWAL 回放日志，得到崩溃前队列中的item，处理中的item排在前面重新处理，然后是按加入顺序排列的 Ready item
+func replayWAL(recs []walRecord, codec Codec) ([]Itemer, error) {
+
+	type node struct {
+		item    Itemer
+		deleted bool
+	}
+	var ready []*node
+	readyByID := make(map[string]*node, 0)
+
+	type processingNode struct {
+		item Itemer
+		seq  int //被取出的顺序
+	}
+	processing := make(map[string][]processingNode, 0)
+
+	for i, rec := range recs {
+		switch rec.op {
+		case walAdd:
+			item, err := codec.Decode(rec.data)
+			if err != nil {
+				return nil, err
+			}
+			if n, ok := readyByID[rec.id]; ok {
+				n.item = item
+				continue
+			}
+			n := &node{item: item}
+			ready = append(ready, n)
+			readyByID[rec.id] = n
+
+		case walGet:
+			n, ok := readyByID[rec.id]
+			if !ok {
+				continue
+			}
+			n.deleted = true
+			delete(readyByID, rec.id)
+			processing[rec.id] = append(processing[rec.id], processingNode{item: n.item, seq: i})
+
+		case walRemove:
+			n, ok := readyByID[rec.id]
+			if !ok {
+				continue
+			}
+			n.deleted = true
+			delete(readyByID, rec.id)
+
+		case walDone:
+			if p := processing[rec.id]; len(p) > 1 {
+				processing[rec.id] = p[1:]
+			} else {
+				delete(processing, rec.id)
+			}
+
+		default:
+			return nil, ErrWALOp
+		}
+	}
+
+	//处理中的item按取出的顺序重新加入，相同的item已经在队列中了就不用再加入
+	var inprocess []processingNode
+	for id, p := range processing {
+		if _, ok := readyByID[id]; ok {
+			continue
+		}
+		inprocess = append(inprocess, p[0])
+	}
+	sort.Slice(inprocess, func(i, j int) bool {
+		return inprocess[i].seq < inprocess[j].seq
+	})
+
+	items := make([]Itemer, 0, len(inprocess)+len(readyByID))
+	for _, p := range inprocess {
+		items = append(items, p.item)
+	}
+	for _, n := range ready {
+		if !n.deleted {
+			items = append(items, n.item)
+		}
+	}
+	return items, nil
+}
@@ -0,0 +1,98 @@
+/*
+ * @Description:
+ * @Version: 2.0
+ * @Author: kingeasternsun
+ * @Date: 2026-10-19 20:15:30
+ * @LastEditors: kingeasternsun
+ * @LastEditTime: 2026-10-27 14:52:10
+ * @FilePath: \tidb\two\wal_test.go
+ */
+package two
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestReadRecords(t *testing.T) {
+
+	buf := &bytes.Buffer{}
+	buf.Write(encodeRecord(walAdd, "a", []byte("data")))
+	buf.Write(encodeRecord(walGet, "a", nil))
+	buf.Write(encodeRecord(walDone, "a", nil))
+
+	recs, err := readRecords(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Fatalf("records %v not equal 3", len(recs))
+	}
+	if recs[0].op != walAdd || recs[0].id != "a" || string(recs[0].data) != "data" {
+		t.Errorf("record %v", recs[0])
+	}
+	if recs[2].op != walDone || recs[2].id != "a" || len(recs[2].data) != 0 {
+		t.Errorf("record %v", recs[2])
+	}
+
+	//crc 不对的记录和之后的记录都被丢弃
+	data := buf.Bytes()
+	data[len(data)-1] ^= 0xff
+	recs, err = readRecords(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 {
+		t.Errorf("records %v not equal 2", len(recs))
+	}
+
+	//length 写坏了，超过最大长度的当作没写完的记录
+	data[len(data)-1] ^= 0xff
+	data = append(data, 0xff, 0xff, 0xff, 0xff, 0, 0, 0, 0, walAdd)
+	recs, err = readRecords(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 3 {
+		t.Errorf("records %v not equal 3", len(recs))
+	}
+
+}
+
+func TestReplayWAL(t *testing.T) {
+
+	codec := JSONCodec[StringItem]{}
+	add := func(id string) walRecord {
+		data, _ := codec.Encode(StringItem(id))
+		return walRecord{op: walAdd, id: id, data: data}
+	}
+
+	items, err := replayWAL([]walRecord{
+		add("a"), add("b"), add("c"),
+		{op: walGet, id: "b"},
+		{op: walGet, id: "a"},
+		{op: walGet, id: "c"},
+		{op: walDone, id: "c"},
+		add("d"),
+	}, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"b", "a", "d"}
+	if len(items) != len(want) {
+		t.Fatalf("items %v not equal %v", items, want)
+	}
+	for i := range items {
+		if items[i].GetID() != want[i] {
+			t.Errorf("%v not equal %v", items[i], want[i])
+		}
+	}
+
+	_, err = replayWAL([]walRecord{{op: 9, id: "a"}}, codec)
+	if !errors.Is(err, ErrWALOp) {
+		t.Errorf("err %v not %v", err, ErrWALOp)
+	}
+
+}